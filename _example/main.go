@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/FreeFlow/sphero"
 	"os"
@@ -22,7 +23,7 @@ func main() {
 		var d SensorData
 		for r := range async {
 			r.Sensors(&d)
-			fmt.Printf("Async: %#v\n", r, d)
+			fmt.Printf("Async: %#v %#v\n", r, d)
 		}
 	}()
 
@@ -50,28 +51,45 @@ func main() {
 
 	fmt.Println("Connected.")
 
-	var res *sphero.Response
-	ch := make(chan *sphero.Response, 1)
+	// Every command waits at most a few seconds for the Sphero to answer
+	timeout := func() (context.Context, context.CancelFunc) {
+		return context.WithTimeout(context.Background(), 5*time.Second)
+	}
 
 	// Send a ping command to verify that we're working
 	fmt.Println("Ping...")
-	s.Ping(ch)
-	res = <-ch
-	fmt.Printf("Pong %#x\n", res)
+	ctx, cancel := timeout()
+	err = s.PingContext(ctx)
+	cancel()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("Pong")
 
 	// Enable data streaming - async messages are captured in the above goroutine
 	fmt.Println("Enabling streaming...")
 	// 400hz / (N = 400): 1hz or 1 async response per second
-	s.SetDataStreaming(400, 1, 0, []uint32{sphero.ACCEL_RAW}, []uint32{}, ch)
-	res = <-ch
-	fmt.Printf("Streaming enabled %#x\n", res)
+	ctx, cancel = timeout()
+	err = s.SetDataStreamingContext(ctx, 400, 1, 0, []uint32{sphero.ACCEL_RAW}, []uint32{})
+	cancel()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("Streaming enabled")
 
 	fmt.Println("Press Ctrl+C to QUIT")
 	<-sig
 
 	// Sleeping the Sphero
 	fmt.Println("Sleeping...")
-	s.Sleep(time.Duration(0), 0, 0, ch)
-	res = <-ch
-	fmt.Printf("Slept %#x\n", res)
+	ctx, cancel = timeout()
+	err = s.SleepContext(ctx, time.Duration(0), 0, 0)
+	cancel()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("Slept")
 }
@@ -0,0 +1,119 @@
+package sphero
+
+import (
+	"context"
+)
+
+// Calibration is an interactive aiming session, in which the user lines the
+// Sphero's tail light up with themselves so that heading 0 points away from
+// them. Start one with BeginCalibration.
+//
+// While aiming stabilization is off, so the user can turn the Sphero by
+// hand. Rotate turns it in place from the app instead, e.g. from keyboard or
+// joystick input, which needs stabilization and so turns it back on. Commit
+// makes the direction the Sphero faces its new zero heading, Cancel leaves
+// the heading alone, and both restore the tail light and stabilization as
+// they were before the session.
+//
+// A Calibration is not safe for concurrent use.
+type Calibration struct {
+	s       *Sphero
+	heading uint16 // Heading the Sphero was last turned to
+	rotated bool   // Rotate turned stabilization on
+	done    bool
+
+	// Payloads restoring the state before the session.
+	backLED, stabilization []byte
+}
+
+// BeginCalibration starts aiming: it lights the tail light at `brightness`
+// and turns stabilization off.
+func (s *Sphero) BeginCalibration(ctx context.Context, brightness uint8) (*Calibration, error) {
+	c := &Calibration{
+		s:             s,
+		heading:       s.lastHeading(),
+		backLED:       backLEDData(0),          // Off at power on
+		stabilization: stabilizationData(true), // On at power on
+	}
+
+	s.mu.Lock()
+	if data, ok := s.config[cmdKey(DID_SPHERO, CMD_SET_BACK_LED)]; ok {
+		c.backLED = data
+	}
+	if data, ok := s.config[cmdKey(DID_SPHERO, CMD_SET_STABILIZ)]; ok {
+		c.stabilization = data
+	}
+	s.mu.Unlock()
+
+	if err := s.SetBackLEDOutputContext(ctx, brightness); err != nil {
+		return nil, err
+	}
+	if err := s.SetStabilizationContext(ctx, false); err != nil {
+		c.restore(ctx)
+		return nil, err
+	}
+	return c, nil
+}
+
+// Heading returns the heading Rotate last turned the Sphero to, relative to
+// the zero heading before the session.
+func (c *Calibration) Heading() uint16 {
+	return c.heading
+}
+
+// Rotate turns the Sphero in place by `degrees`, clockwise if positive.
+func (c *Calibration) Rotate(ctx context.Context, degrees int) error {
+	if c.done {
+		return CalibrationDoneError
+	}
+	if !c.rotated {
+		if err := c.s.SetStabilizationContext(ctx, true); err != nil {
+			return err
+		}
+		c.rotated = true
+	}
+
+	heading := uint16(((int(c.heading)+degrees)%360 + 360) % 360)
+	if err := c.s.RollContext(ctx, 0, heading, RollNormal); err != nil {
+		return err
+	}
+	c.heading = heading
+	return nil
+}
+
+// Commit makes the direction the Sphero faces its zero heading and ends the
+// session.
+func (c *Calibration) Commit(ctx context.Context) error {
+	if c.done {
+		return CalibrationDoneError
+	}
+	c.done = true
+
+	if err := c.s.SetHeadingContext(ctx, 0); err != nil {
+		c.restore(ctx)
+		return err
+	}
+	c.s.mu.Lock()
+	c.s.heading = 0
+	c.s.mu.Unlock()
+	return c.restore(ctx)
+}
+
+// Cancel ends the session without changing the zero heading.
+func (c *Calibration) Cancel(ctx context.Context) error {
+	if c.done {
+		return CalibrationDoneError
+	}
+	c.done = true
+	return c.restore(ctx)
+}
+
+// Puts the tail light and stabilization back as they were, returning the
+// first error.
+func (c *Calibration) restore(ctx context.Context) error {
+	_, err := c.s.Do(ctx, DID_SPHERO, CMD_SET_STABILIZ, c.stabilization)
+	if _, lerr := c.s.Do(ctx, DID_SPHERO, CMD_SET_BACK_LED, c.backLED); err == nil {
+		err = lerr
+	}
+	return err
+}
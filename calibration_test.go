@@ -0,0 +1,88 @@
+package sphero_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/FreeFlow/sphero"
+)
+
+func TestCalibration(t *testing.T) {
+	sim, s, _ := connect(t)
+	ctx := timeout(t, time.Second)
+
+	if err := s.SetHeadingContext(ctx, 45); err != nil {
+		t.Fatal(err)
+	}
+	if st := sim.State(); st.Calibration != 45 {
+		t.Fatalf("Expected SetHeading to calibrate but got %+v", st)
+	}
+
+	c, err := s.BeginCalibration(ctx, 0xff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := sim.State(); st.BackLED != 0xff || st.Stabilization {
+		t.Fatalf("Expected the tail light on and stabilization off but got %+v", st)
+	}
+
+	if err := c.Rotate(ctx, 90); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rotate(ctx, -100); err != nil {
+		t.Fatal(err)
+	}
+	if st := sim.State(); !st.Stabilization || st.Heading != 350 || st.Speed != 0 {
+		t.Fatalf("Expected to turn in place to 350 but got %+v", st)
+	}
+	if c.Heading() != 350 {
+		t.Fatalf("Expected heading 350 but got %d", c.Heading())
+	}
+
+	if err := c.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if st := sim.State(); st.Calibration != 0 || st.BackLED != 0 || !st.Stabilization {
+		t.Fatalf("Expected a new zero heading and the previous state but got %+v", st)
+	}
+	if err := c.Commit(ctx); err != sphero.CalibrationDoneError {
+		t.Fatalf("Expected CalibrationDoneError but got %v", err)
+	}
+
+	// Stop holds the new zero heading.
+	if err := s.StopContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if st := sim.State(); st.Heading != 0 {
+		t.Fatalf("Expected Stop to hold heading 0 but got %d", st.Heading)
+	}
+}
+
+func TestCalibrationCancel(t *testing.T) {
+	sim, s, _ := connect(t)
+	ctx := timeout(t, time.Second)
+
+	if err := s.SetBackLEDOutputContext(ctx, 0x20); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetStabilizationContext(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := s.BeginCalibration(ctx, 0x80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rotate(ctx, 30); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Cancel(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if st := sim.State(); st.BackLED != 0x20 || st.Stabilization {
+		t.Fatalf("Expected the previous state back but got %+v", st)
+	}
+	if err := c.Rotate(ctx, 30); err != sphero.CalibrationDoneError {
+		t.Fatalf("Expected CalibrationDoneError but got %v", err)
+	}
+}
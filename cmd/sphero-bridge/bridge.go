@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// How long a write to the client may take before the client is dropped, so
+// one that stops reading can't hold up the device.
+const clientWriteTimeout = time.Second
+
+// bridge forwards bytes between a device and the current network client.
+type bridge struct {
+	dev io.ReadWriter
+
+	mu     sync.Mutex
+	client net.Conn
+	err    error // Device failure, after which no clients are served
+}
+
+func newBridge(dev io.ReadWriter) *bridge {
+	return &bridge{dev: dev}
+}
+
+// Accepts clients from `l` until it or the device fails. Device output is
+// forwarded to whichever client is connected and dropped while none is. When
+// the device fails the listener and the client are closed, so the client
+// sees the connection drop.
+func (b *bridge) serve(l net.Listener) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- b.readDevice()
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case err := <-errc:
+			b.mu.Lock()
+			b.err = err
+			if b.client != nil {
+				b.client.Close()
+				b.client = nil
+			}
+			b.mu.Unlock()
+			l.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		conn, err := l.Accept()
+
+		b.mu.Lock()
+		if devErr := b.err; devErr != nil {
+			b.mu.Unlock()
+			if conn != nil {
+				conn.Close()
+			}
+			return devErr
+		}
+		if err != nil {
+			b.mu.Unlock()
+			return err
+		}
+		if b.client != nil {
+			log.Printf("Replacing client %s", b.client.RemoteAddr())
+			b.client.Close()
+		}
+		b.client = conn
+		b.mu.Unlock()
+
+		log.Printf("Client %s connected", conn.RemoteAddr())
+		go b.readClient(conn)
+	}
+}
+
+// Copies device output to the current client.
+func (b *bridge) readDevice() error {
+	buf := make([]byte, 256)
+	for {
+		n, err := b.dev.Read(buf)
+		if n > 0 {
+			b.mu.Lock()
+			client := b.client
+			b.mu.Unlock()
+
+			if client != nil {
+				client.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+				if _, err := client.Write(buf[:n]); err != nil {
+					// Gone or not keeping up, readClient cleans up.
+					log.Printf("Client %s: %v", client.RemoteAddr(), err)
+					client.Close()
+				}
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Copies client input to the device until the client disconnects.
+func (b *bridge) readClient(conn net.Conn) {
+	if _, err := io.Copy(b.dev, conn); err != nil {
+		log.Printf("Client %s: %v", conn.RemoteAddr(), err)
+	}
+
+	b.mu.Lock()
+	if b.client == conn {
+		b.client = nil
+	}
+	b.mu.Unlock()
+
+	conn.Close()
+	log.Printf("Client %s disconnected", conn.RemoteAddr())
+}
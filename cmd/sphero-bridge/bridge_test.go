@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/FreeFlow/sphero"
+	"github.com/FreeFlow/sphero/spherosim"
+)
+
+func TestBridge(t *testing.T) {
+	log.SetOutput(io.Discard)
+
+	sim := spherosim.New()
+	defer sim.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go newBridge(sim.Conn()).serve(l)
+
+	async := make(chan *sphero.AsyncResponse, 1)
+	s, err := sphero.Dial("tcp", l.Addr().String(), async)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if s.Name() != "tcp://"+l.Addr().String() {
+		t.Fatalf("Unexpected name %q", s.Name())
+	}
+
+	ch := make(chan *sphero.Response, 1)
+	s.ConfigureCollisionDetection(0x01, 40, 40, 50, 50, 10, ch)
+	select {
+	case r := <-ch:
+		if err := r.Error(); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for response")
+	}
+
+	sim.Collide(sphero.Collision{X: 1})
+	select {
+	case r := <-async:
+		if r.IdCode != sphero.ID_COLLISION_DETECTED {
+			t.Fatalf("Unexpected async response %#v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for async response")
+	}
+}
+
+// A device whose reads fail once `fail` is closed.
+type failingDevice struct {
+	fail chan struct{}
+}
+
+func (d *failingDevice) Read(p []byte) (int, error) {
+	<-d.fail
+	return 0, errors.New("device gone")
+}
+
+func (d *failingDevice) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func TestBridgeDeviceFailure(t *testing.T) {
+	log.SetOutput(io.Discard)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	dev := &failingDevice{fail: make(chan struct{})}
+	served := make(chan error, 1)
+	go func() {
+		served <- newBridge(dev).serve(l)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond) // Let the bridge take the client
+
+	close(dev.fail)
+	select {
+	case err := <-served:
+		if err == nil || err.Error() != "device gone" {
+			t.Fatalf("Expected the device error but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Bridge kept serving after the device failed")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Expected the client to see EOF but got %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+// Command sphero-bridge exposes a Sphero's serial device on a TCP port so it
+// can be driven from another machine with sphero.Dial:
+//
+//	sphero-bridge -device /dev/cu.Sphero-YBR-RN-SPP -listen :7050
+//
+// The raw byte stream is forwarded unchanged in both directions. Only one
+// client is served at a time, a new client replaces the current one.
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+
+	"github.com/FreeFlow/sphero"
+)
+
+func main() {
+	device := flag.String("device", "", "Sphero serial device, e.g. /dev/cu.Sphero-YBR-RN-SPP")
+	addr := flag.String("listen", ":7050", "TCP address to listen on")
+	flag.Parse()
+
+	if *device == "" {
+		flag.Usage()
+		return
+	}
+
+	dev, err := sphero.OpenSerial(*device)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer dev.Close()
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Bridging %s on %s", dev.Name(), l.Addr())
+
+	log.Fatal(newBridge(dev).serve(l))
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/FreeFlow/sphero"
+	"github.com/FreeFlow/sphero/codec"
+)
+
+// dissector annotates the frames in a byte stream. Commands and responses
+// are kept apart, as they travel in opposite directions and their SOP2
+// values overlap.
+type dissector struct {
+	w   io.Writer
+	buf [2][]byte // Unparsed bytes, by direction
+	at  [2]int    // Stream offset of buf, by direction
+
+	// Commands by seq, so answers can be decoded in the layout of the
+	// command they answer.
+	sent map[uint8]*sphero.Command
+}
+
+func newDissector(w io.Writer) *dissector {
+	return &dissector{w: w, sent: make(map[uint8]*sphero.Command)}
+}
+
+// Dissects the next chunk of bytes traveling in direction `dir`. Frames may
+// span chunks. `offset` is when the chunk was recorded, negative if unknown.
+func (d *dissector) feed(dir sphero.Direction, offset time.Duration, data []byte) {
+	d.buf[dir] = append(d.buf[dir], data...)
+
+	parse := codec.ParseResponse
+	if dir == sphero.DirWrite {
+		parse = codec.ParseCommand
+	}
+	for len(d.buf[dir]) > 0 {
+		buf := d.buf[dir]
+		p, n, err := parse(buf)
+
+		var fe *codec.FrameError
+		if errors.As(err, &fe) && fe.Packet != nil {
+			// Show the frame anyway and skip all of it, its length was sound.
+			frame, _ := fe.Packet.MarshalBinary()
+			n = len(frame)
+			frame[n-1] = buf[n-1]
+			d.frame(dir, offset, frame, fe.Packet, fe.Detail)
+		} else if err != nil {
+			d.header(dir, offset, buf[:n])
+			fmt.Fprintf(d.w, "  error: %v\n", err)
+		} else if n > 0 {
+			d.frame(dir, offset, buf[:n], p, "")
+		}
+		if n == 0 {
+			break
+		}
+		d.at[dir] += n
+		d.buf[dir] = buf[n:]
+	}
+}
+
+// Reports any incomplete frame left at the end of the input.
+func (d *dissector) flush() {
+	for dir, buf := range d.buf {
+		if len(buf) > 0 {
+			d.header(sphero.Direction(dir), -1, buf)
+			fmt.Fprintf(d.w, "  error: incomplete frame, %d bytes\n", len(buf))
+		}
+		d.buf[dir] = nil
+	}
+}
+
+func (d *dissector) header(dir sphero.Direction, offset time.Duration, frame []byte) {
+	at := fmt.Sprintf("%s@%d", dir, d.at[dir])
+	if offset >= 0 {
+		at += fmt.Sprintf(" +%.3fs", offset.Seconds())
+	}
+	fmt.Fprintf(d.w, "%-22s % x\n", at, frame)
+}
+
+// Prints a frame with its annotations. `bad` describes a checksum mismatch.
+func (d *dissector) frame(dir sphero.Direction, offset time.Duration, frame []byte, p codec.Packet, bad string) {
+	d.header(dir, offset, frame)
+
+	check := "checksum ok"
+	if bad != "" {
+		check = "checksum BAD, " + bad
+	}
+
+	switch p := p.(type) {
+	case *codec.CommandPacket:
+		mode := sphero.SendMode(p.Sop2)
+		fmt.Fprintf(d.w, "  command %s %s seq %#02x, sop2 %#02x (%s), %s\n",
+			sphero.DeviceName(p.Did), sphero.CommandName(p.Did, p.Cid), p.Seq, p.Sop2, mode, check)
+		c, ok := sphero.LookupCommand(p.Did, p.Cid)
+		if ok && mode.Answer() {
+			d.sent[p.Seq] = c
+		}
+		if ok && len(p.Data) > 0 {
+			d.fields(c.DecodeRequest(p.Data))
+		}
+
+	case *codec.AnswerPacket:
+		c := d.sent[p.Seq]
+		delete(d.sent, p.Seq)
+		meaning := "ok"
+		if err := (&sphero.Response{Mrsp: p.Mrsp}).Error(); err != nil {
+			meaning = err.Error()
+		}
+		to := ""
+		if c != nil {
+			to = " to " + c.Name
+		}
+		fmt.Fprintf(d.w, "  answer seq %#02x%s, %s (%s), %s\n",
+			p.Seq, to, sphero.ResponseCodeName(p.Mrsp), meaning, check)
+		if c != nil && len(p.Data) > 0 {
+			d.fields(c.DecodeResponse(p.Data))
+		} else if len(p.Data) > 0 {
+			fmt.Fprintf(d.w, "    data %x\n", p.Data)
+		}
+
+	case *codec.AsyncPacket:
+		fmt.Fprintf(d.w, "  async %s, %d bytes, %s\n", sphero.AsyncIdName(p.IdCode), len(p.Data), check)
+		if a, ok := sphero.LookupAsync(p.IdCode); ok && len(p.Data) > 0 {
+			d.fields(a.Decode(p.Data))
+		} else if len(p.Data) > 0 {
+			fmt.Fprintf(d.w, "    data %x\n", p.Data)
+		}
+	}
+}
+
+func (d *dissector) fields(vs sphero.Values, err error) {
+	if len(vs) > 0 {
+		fmt.Fprintf(d.w, "    %s\n", vs)
+	}
+	if err != nil {
+		fmt.Fprintf(d.w, "    error: %v\n", err)
+	}
+}
+
+// Dissects a whole input in the given format: "hex", "raw", "rec" or "auto"
+// to guess. Hex and raw input travel in direction `dir`.
+func dissect(w io.Writer, r io.Reader, format string, dir sphero.Direction) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if format == "auto" {
+		format = guessFormat(data)
+	}
+
+	d := newDissector(w)
+	switch format {
+	case "rec":
+		rr, err := sphero.NewRecordingReader(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		for {
+			rec, err := rr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				d.flush()
+				return err
+			}
+			d.feed(rec.Dir, rec.Offset, rec.Data)
+		}
+	case "hex":
+		b, err := parseHex(string(data))
+		if err != nil {
+			return err
+		}
+		d.feed(dir, -1, b)
+	case "raw":
+		d.feed(dir, -1, data)
+	default:
+		return fmt.Errorf("Unknown format %q", format)
+	}
+	d.flush()
+	return nil
+}
+
+// Recordings start with their magic, text made only of hex digits and
+// separators is a hex dump and anything else raw bytes.
+func guessFormat(data []byte) string {
+	if bytes.HasPrefix(data, []byte("SPRC")) {
+		return "rec"
+	}
+	if _, err := parseHex(string(data)); err == nil && len(bytes.TrimSpace(data)) > 0 {
+		return "hex"
+	}
+	return "raw"
+}
+
+// Parses a hex dump as pasted from a sniffer: "ff ff 00 01 01 fe",
+// "FF:FF:00", "0xff, 0xff" or "ffff0001". Anything from a '#' to the end of
+// the line is a comment.
+func parseHex(s string) ([]byte, error) {
+	var digits strings.Builder
+	for _, line := range strings.Split(s, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		for _, field := range strings.FieldsFunc(line, isHexSeparator) {
+			field = strings.TrimPrefix(strings.TrimPrefix(field, "0x"), "0X")
+			if len(field)%2 != 0 {
+				return nil, fmt.Errorf("Odd number of hex digits in %q", field)
+			}
+			digits.WriteString(field)
+		}
+	}
+
+	out, err := hex.DecodeString(digits.String())
+	if err != nil {
+		return nil, fmt.Errorf("Invalid hex: %v", err)
+	}
+	return out, nil
+}
+
+func isHexSeparator(r rune) bool {
+	switch r {
+	case ' ', '\t', '\r', ',', ':', '-':
+		return true
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/FreeFlow/sphero"
+	"github.com/FreeFlow/sphero/spherosim"
+)
+
+func TestDissectHex(t *testing.T) {
+	in := `
+# SetRGBLED answered with OK, then a ping with a bad checksum
+0xff, 0xff, 0x00, 0x01, 0x01, 0xfd
+FF:FF:00:02:01:00
+ff fe 01 00 02 02 fa  # Power notification: battery OK
+`
+	var out bytes.Buffer
+	if err := dissect(&out, strings.NewReader(in), "auto", sphero.DirRead); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"answer seq 0x01, ORBOTIX_RSP_CODE_OK (ok), checksum ok",
+		"answer seq 0x02, ORBOTIX_RSP_CODE_OK (ok), checksum BAD, expected 0x0 but computed 0xfc",
+		"async ID_POWER_NOTIFICATIONS, 1 bytes, checksum ok",
+		"power_state=2",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected output to contain %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "error") {
+		t.Errorf("Unexpected error in output:\n%s", out.String())
+	}
+}
+
+func TestDissectCommands(t *testing.T) {
+	in := "ff fe 02 20 00 05 ff 00 00 01 d8 ff ff 02 21 07 02 80 53 ff ff 02 21"
+	var out bytes.Buffer
+	if err := dissect(&out, strings.NewReader(in), "hex", sphero.DirWrite); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"command DID_SPHERO CMD_SET_RGB_LED seq 0x00, sop2 0xfe (no answer), checksum ok",
+		"red=255 green=0 blue=0 user=true",
+		"command DID_SPHERO CMD_SET_BACK_LED seq 0x07, sop2 0xff (answer), checksum ok",
+		"brightness=128",
+		"error: incomplete frame, 4 bytes",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected output to contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+// The example in the package documentation dissects cleanly.
+func TestDissectDocExample(t *testing.T) {
+	src, err := os.ReadFile("main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := regexp.MustCompile(`echo "([0-9a-f ]+)" \| sphero-dissect -from host`).FindSubmatch(src)
+	if m == nil {
+		t.Fatal("No example found in main.go")
+	}
+
+	var out bytes.Buffer
+	if err := dissect(&out, bytes.NewReader(m[1]), "auto", sphero.DirWrite); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"command DID_SPHERO CMD_ROLL seq 0x01, sop2 0xff (answer), checksum ok",
+		"speed=128 heading=90 state=1",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected output to contain %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "error") {
+		t.Errorf("Unexpected error in output:\n%s", out.String())
+	}
+}
+
+func TestDissectRecording(t *testing.T) {
+	sim := spherosim.New()
+	defer sim.Close()
+
+	var rec bytes.Buffer
+	conn, err := sphero.NewRecorder(sim.Conn(), &rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := sphero.NewSpheroConn(conn, nil)
+	if _, err := s.GetPowerStateContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	var out bytes.Buffer
+	if err := dissect(&out, &rec, "auto", sphero.DirRead); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"command DID_CORE CMD_GET_PWR_STATE seq",
+		"to CMD_GET_PWR_STATE, ORBOTIX_RSP_CODE_OK (ok), checksum ok",
+		"rec_ver=1 power_state=",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected output to contain %q:\n%s", want, out.String())
+		}
+	}
+}
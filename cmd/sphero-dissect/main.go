@@ -0,0 +1,66 @@
+// Command sphero-dissect prints every frame in a hex dump, raw capture or
+// session recording, annotated with its start of packet, device and command
+// names, seq, payload fields, checksum validity and response code:
+//
+//	echo "ff ff 02 30 01 05 80 00 5a 01 ec" | sphero-dissect -from host
+//	sphero-dissect session.sprc
+//
+// Recordings (see sphero.NewRecorder) tell commands and responses apart. A
+// hex dump or raw capture holds one direction, from the Sphero by default.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/FreeFlow/sphero"
+)
+
+func main() {
+	format := flag.String("format", "auto", "Input format: hex, raw, rec or auto")
+	from := flag.String("from", "sphero", "Sender of hex and raw input: sphero or host")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [file ...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetFlags(0)
+
+	var dir sphero.Direction
+	switch *from {
+	case "sphero":
+		dir = sphero.DirRead
+	case "host":
+		dir = sphero.DirWrite
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if flag.NArg() == 0 {
+		if err := dissect(os.Stdout, os.Stdin, *format, dir); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	for _, name := range flag.Args() {
+		if err := dissectFile(name, *format, dir); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func dissectFile(name, format string, dir sphero.Direction) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if flag.NArg() > 1 {
+		fmt.Printf("== %s\n", name)
+	}
+	return dissect(os.Stdout, f, format, dir)
+}
@@ -0,0 +1,464 @@
+// Package codec encodes and decodes Sphero packets. It's shared by the
+// client in package sphero, the virtual Sphero in spherosim and the command
+// line tools.
+//
+// Commands travel from the client to the Sphero:
+//
+//	SOP1 SOP2 DID CID SEQ DLEN <DLEN-1 data bytes> CHK
+//
+// Answers and async packets travel the other way:
+//
+//	SOP1 SOP2_ANSWER MRSP SEQ DLEN <DLEN-1 data bytes> CHK
+//	SOP1 SOP2_ASYNC ID_CODE DLEN_MSB DLEN_LSB <DLEN-1 data bytes> CHK
+//
+// CHK is the bit inverted modulo 256 sum of every byte after SOP2.
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Start of Packet values
+const (
+	SOP1                     = 0xff
+	SOP2_ANSWER              = 0xff
+	SOP2_ASYNC               = 0xfe
+	SOP2_RESET_TIMEOUT       = 0xfd
+	SOP2_ASYNC_RESET_TIMEOUT = 0xfc
+)
+
+// Largest data portions that fit a packet's DLEN, which counts the checksum.
+const (
+	MaxCommandData = 0xff - 1
+	MaxAnswerData  = 0xff - 1
+	MaxAsyncData   = 0xffff - 1
+)
+
+var (
+	BadSOPError          = errors.New("Bad start of packet")
+	BadLengthError       = errors.New("Bad data length")
+	InvalidChecksumError = errors.New("Invalid checksum")
+	DataTooLongError     = errors.New("Data too long for packet")
+)
+
+// FrameError reports a malformed frame. The bytes responsible have already
+// been skipped, so decoding can carry on.
+type FrameError struct {
+	Err    error  // BadSOPError, BadLengthError or InvalidChecksumError
+	Detail string // Human readable specifics
+	Packet Packet // For checksum failures, the packet as received
+}
+
+func (e *FrameError) Error() string {
+	if e.Detail == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ": " + e.Detail
+}
+
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+// Packet is a CommandPacket, AnswerPacket or AsyncPacket.
+type Packet interface {
+	MarshalBinary() ([]byte, error)
+}
+
+// CommandPacket is a command sent to the Sphero.
+type CommandPacket struct {
+	Sop2 byte // SOP2_ANSWER or another SOP2 variant
+	Did  uint8
+	Cid  uint8
+	Seq  uint8
+	Data []byte
+}
+
+// MarshalBinary encodes the command as a frame.
+func (p *CommandPacket) MarshalBinary() ([]byte, error) {
+	if len(p.Data) > MaxCommandData {
+		return nil, fmt.Errorf("%w: %d bytes", DataTooLongError, len(p.Data))
+	}
+	buf := make([]byte, 0, 7+len(p.Data))
+	buf = append(buf, SOP1, p.Sop2, p.Did, p.Cid, p.Seq, uint8(len(p.Data)+1))
+	buf = append(buf, p.Data...)
+	return append(buf, Checksum(buf[2:])), nil
+}
+
+// AnswerPacket is the Sphero's answer to a command.
+type AnswerPacket struct {
+	Mrsp uint8 // Message response code
+	Seq  uint8 // Seq of the command answered
+	Data []byte
+}
+
+// MarshalBinary encodes the answer as a frame.
+func (p *AnswerPacket) MarshalBinary() ([]byte, error) {
+	if len(p.Data) > MaxAnswerData {
+		return nil, fmt.Errorf("%w: %d bytes", DataTooLongError, len(p.Data))
+	}
+	buf := make([]byte, 0, 6+len(p.Data))
+	buf = append(buf, SOP1, SOP2_ANSWER, p.Mrsp, p.Seq, uint8(len(p.Data)+1))
+	buf = append(buf, p.Data...)
+	return append(buf, Checksum(buf[2:])), nil
+}
+
+// AsyncPacket is a message the Sphero sends on its own, e.g. streamed
+// sensor data.
+type AsyncPacket struct {
+	IdCode uint8
+	Data   []byte
+}
+
+// MarshalBinary encodes the async packet as a frame.
+func (p *AsyncPacket) MarshalBinary() ([]byte, error) {
+	if len(p.Data) > MaxAsyncData {
+		return nil, fmt.Errorf("%w: %d bytes", DataTooLongError, len(p.Data))
+	}
+	dlen := len(p.Data) + 1
+	buf := make([]byte, 0, 6+len(p.Data))
+	buf = append(buf, SOP1, SOP2_ASYNC, p.IdCode, uint8(dlen>>8), uint8(dlen))
+	buf = append(buf, p.Data...)
+	return append(buf, Checksum(buf[2:])), nil
+}
+
+// Checksum computes the modulo 256 sum of the bytes, bit inverted (1's
+// complement). The value is used as a verification on commands and
+// responses.
+func Checksum(data []byte) uint8 {
+	var sum uint8
+	for _, b := range data {
+		sum += b
+	}
+	return ^sum
+}
+
+// Data lengths of the async packets the Sphero sends, by ID code. Lengths
+// outside these ranges can only come from line noise that happens to look
+// like a header, so they're rejected before waiting on bytes that may never
+// arrive, as are ID codes the protocol doesn't define. Text messages have
+// no fixed length, their bounds are well above anything the firmware sends.
+var asyncLimits = map[uint8]struct{ min, max, step int }{
+	0x01: {1, 1, 1},            // Power notifications
+	0x02: {1, 0x2000, 1},       // Level 1 diagnostic response, text
+	0x03: {2, MaxAsyncData, 2}, // Sensor data streaming, 2 bytes per sample
+	0x04: {1, 0x400, 1},        // Config block contents
+	0x05: {0, 0, 1},            // Pre-sleep warning
+	0x06: {4, 4, 1},            // Macro markers
+	0x07: {16, 16, 1},          // Collision detected
+	0x08: {1, 0x400, 1},        // orbBasic PRINT message, text
+	0x09: {1, 0x400, 1},        // orbBasic error message, text
+	0x0a: {4, 4, 1},            // orbBasic error message, binary
+	0x0b: {1, 1, 1},            // Self level result
+	0x0c: {1, 1, 1},            // Gyro axis limit exceeded
+}
+
+// ParseResponse parses the answer or async packet at the start of `buf`,
+// returning the packet and the number of bytes it took up. It returns 0 and
+// no error if `buf` doesn't yet hold a complete packet. A *FrameError is
+// returned along with the number of bytes to skip for malformed frames.
+// Packet data aliases `buf`.
+//
+// Bytes that can't start a frame are skipped up to the next plausible start
+// of packet as a single BadSOPError. Only the first byte of other malformed
+// frames is skipped, so a frame hidden behind a corrupt header is still
+// found.
+func ParseResponse(buf []byte) (Packet, int, error) {
+	if n := resync(buf, isResponseSOP2); n > 0 {
+		return nil, n, &FrameError{Err: BadSOPError, Detail: fmt.Sprintf("skipped %d bytes", n)}
+	}
+	if len(buf) < 5 {
+		return nil, 0, nil
+	}
+
+	var n int
+	switch buf[1] {
+	case SOP2_ANSWER:
+		dlen := int(buf[4])
+		if dlen == 0 {
+			return malformed(BadLengthError, "answer DLEN must be at least 1")
+		}
+		if n = 5 + dlen; len(buf) < n {
+			return nil, 0, nil
+		}
+		p := &AnswerPacket{Mrsp: buf[2], Seq: buf[3], Data: buf[5 : n-1]}
+		if err := verify(buf[:n], p); err != nil {
+			return nil, 1, err
+		}
+		return p, n, nil
+	default:
+		dlen := int(buf[3])<<8 | int(buf[4])
+		if dlen == 0 {
+			return malformed(BadLengthError, "async DLEN must be at least 1")
+		}
+		l, ok := asyncLimits[buf[2]]
+		if !ok {
+			return malformed(BadLengthError, fmt.Sprintf("unknown async ID %#02x", buf[2]))
+		}
+		if dlen-1 < l.min || dlen-1 > l.max || (dlen-1)%l.step != 0 {
+			return malformed(BadLengthError, fmt.Sprintf("async ID %#02x can't carry %d bytes", buf[2], dlen-1))
+		}
+		if n = 5 + dlen; len(buf) < n {
+			return nil, 0, nil
+		}
+		p := &AsyncPacket{IdCode: buf[2], Data: buf[5 : n-1]}
+		if err := verify(buf[:n], p); err != nil {
+			return nil, 1, err
+		}
+		return p, n, nil
+	}
+}
+
+// ParseCommand is ParseResponse for commands sent to the Sphero.
+func ParseCommand(buf []byte) (Packet, int, error) {
+	if n := resync(buf, isCommandSOP2); n > 0 {
+		return nil, n, &FrameError{Err: BadSOPError, Detail: fmt.Sprintf("skipped %d bytes", n)}
+	}
+	if len(buf) < 6 {
+		return nil, 0, nil
+	}
+
+	dlen := int(buf[5])
+	if dlen == 0 {
+		return malformed(BadLengthError, "command DLEN must be at least 1")
+	}
+	n := 6 + dlen
+	if len(buf) < n {
+		return nil, 0, nil
+	}
+	p := &CommandPacket{Sop2: buf[1], Did: buf[2], Cid: buf[3], Seq: buf[4], Data: buf[6 : n-1]}
+	if err := verify(buf[:n], p); err != nil {
+		return nil, 1, err
+	}
+	return p, n, nil
+}
+
+func isResponseSOP2(b byte) bool {
+	return b == SOP2_ANSWER || b == SOP2_ASYNC
+}
+
+func isCommandSOP2(b byte) bool {
+	return b >= SOP2_ASYNC_RESET_TIMEOUT
+}
+
+// Returns the offset of the first plausible start of packet in `buf`: SOP1
+// followed by a valid SOP2, or SOP1 as the last byte. If there is none
+// that's len(buf), skipping everything.
+func resync(buf []byte, sop2 func(byte) bool) int {
+	for i := 0; i < len(buf); i++ {
+		if buf[i] == SOP1 && (i+1 == len(buf) || sop2(buf[i+1])) {
+			return i
+		}
+	}
+	return len(buf)
+}
+
+// Rejects the frame at the start of a buffer, skipping its SOP1.
+func malformed(err error, detail string) (Packet, int, error) {
+	return nil, 1, &FrameError{Err: err, Detail: detail}
+}
+
+// Checks the trailing checksum of a complete frame.
+func verify(frame []byte, p Packet) error {
+	want := frame[len(frame)-1]
+	if got := Checksum(frame[2 : len(frame)-1]); got != want {
+		return &FrameError{
+			Err:    InvalidChecksumError,
+			Detail: fmt.Sprintf("expected %#x but computed %#x", want, got),
+			Packet: p,
+		}
+	}
+	return nil
+}
+
+// Encoder writes packets to an io.Writer, one Write per packet.
+type Encoder struct {
+	w io.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w}
+}
+
+// Encode writes a single packet.
+func (e *Encoder) Encode(p Packet) error {
+	buf, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(buf)
+	return err
+}
+
+// Decoder reads packets from an io.Reader, buffering partial packets across
+// reads.
+type Decoder struct {
+	r     io.Reader
+	parse func([]byte) (Packet, int, error)
+	size  func([]byte) int // Length of the frame at the start of a buffer, if known
+	buf   []byte
+	stats Stats
+	lost  bool   // Bytes were discarded since the last good frame
+	noise []byte // Start of the line noise being skipped
+	nlen  int    // Length of the line noise being skipped
+}
+
+// Stats counts how a Decoder recovered from line noise.
+type Stats struct {
+	Discarded      uint64 // Bytes skipped while looking for a valid frame
+	ChecksumErrors uint64 // Frames that failed their checksum
+	Recovered      uint64 // Good frames decoded after skipping bytes
+}
+
+// Smallest read the decoder makes. Frames longer than this, such as data
+// streaming packets carrying many samples, are read into a buffer sized to
+// fit once their header has arrived.
+const minRead = 256
+
+// NewDecoder returns a decoder for answers and async packets, i.e. the
+// Sphero's side of the conversation.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r, parse: ParseResponse, size: responseSize}
+}
+
+// NewCommandDecoder returns a decoder for commands, i.e. the client's side
+// of the conversation.
+func NewCommandDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r, parse: ParseCommand, size: commandSize}
+}
+
+// Decode returns the next packet. A *FrameError means a malformed frame was
+// skipped and Decode can be called again. Errors from the reader, including
+// io.EOF, are returned as is once the buffered packets are used up; partial
+// packets stay buffered so Decode can be retried after a transient error.
+// The decoder never writes to the data of a packet it has returned, so
+// packets can be handed to other goroutines without copying.
+func (d *Decoder) Decode() (Packet, error) {
+	for {
+		if p, ok, err := d.next(); ok {
+			return p, err
+		}
+
+		d.grow(d.size(d.buf))
+		m, err := d.r.Read(d.buf[len(d.buf):cap(d.buf)])
+		d.buf = d.buf[:len(d.buf)+m]
+		if err != nil {
+			if m > 0 {
+				// Make sure whatever arrived with the error is parsed first.
+				if p, ok, perr := d.next(); ok {
+					return p, perr
+				}
+			}
+			return nil, err
+		}
+	}
+}
+
+// Parses the buffered bytes, reporting whether they held a packet or an
+// error. Line noise is only reported once the next plausible start of packet
+// turns up, so it's a single error however the bytes arrive.
+func (d *Decoder) next() (Packet, bool, error) {
+	p, n, err := d.parse(d.buf)
+	if e, ok := err.(*FrameError); !ok || e.Err != BadSOPError {
+		if n == 0 && err == nil && (d.nlen == 0 || len(d.buf) < 2) {
+			return nil, false, nil
+		}
+		if d.nlen > 0 {
+			return nil, true, d.noiseError() // Then this result on the next call
+		}
+		d.consume(n, err)
+		return p, true, err
+	}
+
+	if len(d.noise) < 8 {
+		d.noise = append(d.noise, d.buf[:min(n, 8-len(d.noise))]...)
+	}
+	d.nlen += n
+	d.consume(n, err)
+	if len(d.buf) < 2 {
+		return nil, false, nil // Maybe a lone SOP1, wait for its SOP2
+	}
+	return nil, true, d.noiseError()
+}
+
+// Returns the error reporting the line noise skipped, and forgets it.
+func (d *Decoder) noiseError() error {
+	err := &FrameError{Err: BadSOPError, Detail: fmt.Sprintf("skipped %d bytes starting % x", d.nlen, d.noise)}
+	if d.nlen == 1 {
+		err = &FrameError{Err: BadSOPError, Detail: fmt.Sprintf("skipped %#02x", d.noise[0])}
+	}
+	d.noise, d.nlen = d.noise[:0], 0
+	return err
+}
+
+// Skips the `n` bytes the parser used, updating the stats.
+func (d *Decoder) consume(n int, err error) {
+	d.buf = d.buf[n:]
+	if err != nil {
+		d.stats.Discarded += uint64(n)
+		if errors.Is(err, InvalidChecksumError) {
+			d.stats.ChecksumErrors++
+		}
+		d.lost = true
+	} else if n > 0 && d.lost {
+		d.stats.Recovered++
+		d.lost = false
+	}
+}
+
+// Makes room to read at least minRead bytes and the rest of a frame of
+// `size` bytes. Buffered bytes move to a new array rather than down the
+// current one, which may hold data of returned packets.
+func (d *Decoder) grow(size int) {
+	need := size - len(d.buf)
+	if need < minRead {
+		need = minRead
+	}
+	if cap(d.buf)-len(d.buf) >= need {
+		return
+	}
+	n := 2 * len(d.buf)
+	if n < len(d.buf)+need {
+		n = len(d.buf) + need
+	}
+	if n < 4*minRead {
+		n = 4 * minRead
+	}
+	d.buf = append(make([]byte, 0, n), d.buf...)
+}
+
+// Returns the length of the answer or async frame at the start of `buf`, or
+// 0 if it isn't known yet.
+func responseSize(buf []byte) int {
+	if len(buf) < 5 || buf[0] != SOP1 {
+		return 0
+	}
+	switch buf[1] {
+	case SOP2_ANSWER:
+		return 5 + int(buf[4])
+	case SOP2_ASYNC:
+		return 5 + (int(buf[3])<<8 | int(buf[4]))
+	}
+	return 0
+}
+
+// Returns the length of the command frame at the start of `buf`, or 0 if
+// it isn't known yet.
+func commandSize(buf []byte) int {
+	if len(buf) < 6 {
+		return 0
+	}
+	return 6 + int(buf[5])
+}
+
+// Buffered returns the number of bytes read but not yet decoded.
+func (d *Decoder) Buffered() int {
+	return len(d.buf)
+}
+
+// Stats returns counts of the bytes and frames skipped so far.
+func (d *Decoder) Stats() Stats {
+	return d.stats
+}
@@ -0,0 +1,331 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+func TestCommandPacket(t *testing.T) {
+	p := &CommandPacket{Sop2: SOP2_ANSWER, Did: 0x02, Cid: 0x20, Seq: 0x07, Data: []byte{0xff, 0x00, 0x80, 0x01}}
+	buf, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{0xff, 0xff, 0x02, 0x20, 0x07, 0x05, 0xff, 0x00, 0x80, 0x01, 0x51}
+	if !bytes.Equal(buf, want) {
+		t.Fatalf("Expected %#x but got %#x", want, buf)
+	}
+
+	got, n, err := ParseCommand(buf)
+	if err != nil || n != len(buf) || !reflect.DeepEqual(got, p) {
+		t.Fatalf("Expected %#v but got %#v, %d, %v", p, got, n, err)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	packets := []Packet{
+		&AnswerPacket{Mrsp: 0x00, Seq: 0x01, Data: []byte{}},
+		&AsyncPacket{IdCode: 0x03, Data: bytes.Repeat([]byte{0x12, 0x34}, 300)},
+		&AnswerPacket{Mrsp: 0x07, Seq: 0x02, Data: []byte{0x01, 0x02, 0x03}},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, p := range packets {
+		if err := enc.Encode(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// One byte at a time exercises every partial read.
+	dec := NewDecoder(iotest.OneByteReader(&buf))
+	for _, want := range packets {
+		got, err := dec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Expected %#v but got %#v", want, got)
+		}
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Fatalf("Expected io.EOF but got %v", err)
+	}
+}
+
+func TestDecoderRetainsPartialPacket(t *testing.T) {
+	frame, _ := (&AnswerPacket{Seq: 0x09, Data: []byte{0x01}}).MarshalBinary()
+
+	r := &chunkReader{chunks: [][]byte{frame[:3], nil, frame[3:]}}
+	dec := NewDecoder(r)
+
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Fatalf("Expected io.EOF but got %v", err)
+	}
+	if dec.Buffered() != 3 {
+		t.Fatalf("Expected 3 buffered bytes but got %d", dec.Buffered())
+	}
+	p, err := dec.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.(*AnswerPacket).Seq != 0x09 {
+		t.Fatalf("Unexpected packet %#v", p)
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	good, _ := (&AnswerPacket{Seq: 0x01}).MarshalBinary()
+	bad, _ := (&AnswerPacket{Seq: 0x02, Data: []byte{0x01}}).MarshalBinary()
+	bad[len(bad)-1]++
+
+	stream := append([]byte{0x00}, bad...)
+	stream = append(stream, good...)
+	dec := NewDecoder(bytes.NewReader(stream))
+
+	if _, err := dec.Decode(); !errors.Is(err, BadSOPError) {
+		t.Fatalf("Expected BadSOPError but got %v", err)
+	}
+
+	_, err := dec.Decode()
+	var frameErr *FrameError
+	if !errors.As(err, &frameErr) || frameErr.Err != InvalidChecksumError {
+		t.Fatalf("Expected InvalidChecksumError but got %v", err)
+	}
+	if frameErr.Packet.(*AnswerPacket).Seq != 0x02 {
+		t.Fatalf("Expected the corrupt packet but got %#v", frameErr.Packet)
+	}
+	if _, err := dec.Decode(); !errors.Is(err, BadSOPError) {
+		t.Fatalf("Expected the rest of the corrupt packet to be skipped but got %v", err)
+	}
+
+	p, err := dec.Decode()
+	if err != nil || p.(*AnswerPacket).Seq != 0x01 {
+		t.Fatalf("Expected to recover but got %#v, %v", p, err)
+	}
+}
+
+// Builds a data streaming payload of `m` frames, each holding `sensors`
+// int16 samples which differ from frame to frame.
+func streamingData(sensors, m int) []byte {
+	data := make([]byte, 0, 2*sensors*m)
+	for f := 0; f < m; f++ {
+		for i := 0; i < sensors; i++ {
+			v := uint16(f*sensors + i)
+			data = append(data, byte(v>>8), byte(v))
+		}
+	}
+	return data
+}
+
+func TestDecoderLargeAsync(t *testing.T) {
+	packets := []Packet{
+		// 13 sensors, e.g. filtered accelerometer, gyro, IMU angles, back
+		// EMF and quaternions, at increasing frames per packet.
+		&AsyncPacket{IdCode: 0x03, Data: streamingData(13, 1)},
+		&AnswerPacket{Mrsp: 0x00, Seq: 0x01, Data: []byte{}},
+		&AsyncPacket{IdCode: 0x03, Data: streamingData(13, 40)},
+		&AsyncPacket{IdCode: 0x03, Data: streamingData(26, 255)},
+		&AnswerPacket{Mrsp: 0x00, Seq: 0x02, Data: []byte{0x01, 0x02, 0x03}},
+		&AsyncPacket{IdCode: 0x02, Data: bytes.Repeat([]byte("diagnostics "), 600)},
+		&AsyncPacket{IdCode: 0x03, Data: streamingData(1, MaxAsyncData/2)}, // The largest possible
+		&AsyncPacket{IdCode: 0x07, Data: make([]byte, 16)},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, p := range packets {
+		if err := enc.Encode(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream := buf.Bytes()
+
+	// Split frames across reads, coalesce several frames into a read, and
+	// lose reads to serial timeouts mid frame.
+	for _, size := range []int{1, 7, 255, 256, 257, 1000, 4096, 70000, len(stream)} {
+		r := &chunkReader{}
+		for i := 0; i < len(stream); i += size {
+			end := i + size
+			if end > len(stream) {
+				end = len(stream)
+			}
+			r.chunks = append(r.chunks, stream[i:end], nil)
+		}
+
+		dec := NewDecoder(r)
+		var got []Packet
+		for len(got) < len(packets) {
+			p, err := dec.Decode()
+			if err == io.EOF {
+				if len(r.chunks) == 0 {
+					break
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%d byte reads: %v", size, err)
+			}
+			got = append(got, p)
+		}
+
+		// Check once everything is decoded, so later reads overwriting
+		// earlier packets would show up.
+		if len(got) != len(packets) {
+			t.Fatalf("%d byte reads: expected %d packets but got %d", size, len(packets), len(got))
+		}
+		for i := range packets {
+			if !reflect.DeepEqual(got[i], packets[i]) {
+				t.Fatalf("%d byte reads: packet %d doesn't match", size, i)
+			}
+		}
+		if dec.Buffered() != 0 {
+			t.Fatalf("%d byte reads: %d bytes left over", size, dec.Buffered())
+		}
+	}
+}
+
+func TestDecoderResync(t *testing.T) {
+	frame := func(p Packet) []byte {
+		b, _ := p.MarshalBinary()
+		return b
+	}
+	answer := frame(&AnswerPacket{Seq: 0x01, Data: []byte{0x10, 0x20}})
+	power := frame(&AsyncPacket{IdCode: 0x01, Data: []byte{0x03}})
+	stream := frame(&AsyncPacket{IdCode: 0x03, Data: streamingData(3, 2)})
+
+	var buf []byte
+	buf = append(buf, 0x12, 0x00, SOP1, 0x34) // Line noise
+	buf = append(buf, answer...)
+	buf = append(buf, SOP1, SOP2_ASYNC, 0x01, 0x00, 0x09) // Power notifications are 1 byte
+	buf = append(buf, power...)
+	buf = append(buf, SOP1, SOP2_ASYNC, 0x03, 0xff, 0xf0) // Streaming data is whole samples
+	buf = append(buf, stream...)
+	buf = append(buf, SOP1, SOP2_ANSWER, 0x00, 0x07, 0x20) // Swallows the next frames
+	buf = append(buf, answer...)
+	buf = append(buf, power...)
+	buf = append(buf, stream...)
+	buf = append(buf, bytes.Repeat([]byte{0x55}, 32)...)
+	buf = append(buf, answer...)
+
+	want := []Packet{
+		&AnswerPacket{Seq: 0x01, Data: []byte{0x10, 0x20}},
+		&AsyncPacket{IdCode: 0x01, Data: []byte{0x03}},
+		&AsyncPacket{IdCode: 0x03, Data: streamingData(3, 2)},
+		&AnswerPacket{Seq: 0x01, Data: []byte{0x10, 0x20}},
+		&AsyncPacket{IdCode: 0x01, Data: []byte{0x03}},
+		&AsyncPacket{IdCode: 0x03, Data: streamingData(3, 2)},
+		&AnswerPacket{Seq: 0x01, Data: []byte{0x10, 0x20}},
+	}
+	// Rejecting a frame skips its SOP1, the rest is line noise.
+	wantErrs := []error{
+		BadSOPError,
+		BadLengthError, BadSOPError,
+		BadLengthError, BadSOPError,
+		InvalidChecksumError, BadSOPError,
+		BadSOPError,
+	}
+
+	// Decoding is the same however the bytes arrive.
+	for _, r := range []io.Reader{bytes.NewReader(buf), iotest.OneByteReader(bytes.NewReader(buf))} {
+		dec := NewDecoder(r)
+		var got []Packet
+		var errs []error
+		for {
+			p, err := dec.Decode()
+			if err == io.EOF {
+				break
+			}
+			var frameErr *FrameError
+			if errors.As(err, &frameErr) {
+				errs = append(errs, frameErr.Err)
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, p)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Expected %d packets but got %d: %#v", len(want), len(got), got)
+		}
+		if !reflect.DeepEqual(errs, wantErrs) {
+			t.Fatalf("Expected errors %v but got %v", wantErrs, errs)
+		}
+		st := dec.Stats()
+		if want := (Stats{Discarded: 4 + 5 + 5 + 5 + 32, ChecksumErrors: 1, Recovered: 5}); st != want {
+			t.Fatalf("Expected %+v but got %+v", want, st)
+		}
+	}
+}
+
+// A header with an undefined async ID must not make the decoder wait for
+// the length it claims.
+func TestDecoderUnknownAsyncId(t *testing.T) {
+	ping, _ := (&AnswerPacket{Seq: 0x01, Data: []byte{}}).MarshalBinary()
+	buf := append([]byte{SOP1, SOP2_ASYNC, 0x77, 0xff, 0xff}, ping...)
+
+	dec := NewDecoder(bytes.NewReader(buf))
+	if _, err := dec.Decode(); !errors.Is(err, BadLengthError) {
+		t.Fatalf("Expected BadLengthError but got %v", err)
+	}
+	for {
+		p, err := dec.Decode()
+		if err == io.EOF {
+			t.Fatal("The answer after the bad header was lost")
+		}
+		var frameErr *FrameError
+		if errors.As(err, &frameErr) {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (&AnswerPacket{Seq: 0x01, Data: []byte{}}); !reflect.DeepEqual(p, want) {
+			t.Fatalf("Expected %#v but got %#v", want, p)
+		}
+		break
+	}
+	if dec.Buffered() != 0 {
+		t.Fatalf("%d bytes left over", dec.Buffered())
+	}
+}
+
+func TestDataTooLong(t *testing.T) {
+	if _, err := (&CommandPacket{Data: make([]byte, MaxCommandData+1)}).MarshalBinary(); !errors.Is(err, DataTooLongError) {
+		t.Fatalf("Expected DataTooLongError but got %v", err)
+	}
+	if _, err := (&AsyncPacket{Data: make([]byte, MaxAsyncData)}).MarshalBinary(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Returns one chunk per Read, with io.EOF for empty chunks like a serial
+// port that timed out. Chunks longer than the read are split.
+type chunkReader struct {
+	chunks [][]byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	c := r.chunks[0]
+	if len(c) == 0 {
+		r.chunks = r.chunks[1:]
+		return 0, io.EOF
+	}
+	n := copy(p, c)
+	if n < len(c) {
+		r.chunks[0] = c[n:]
+	} else {
+		r.chunks = r.chunks[1:]
+	}
+	return n, nil
+}
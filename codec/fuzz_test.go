@@ -0,0 +1,199 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+)
+
+// Frames the Sphero sends, used to seed the fuzz targets. More live in
+// testdata/fuzz.
+var responseSeeds = [][]byte{
+	{0xff, 0xff, 0x00, 0x01, 0x01, 0xfd},                                                 // Ping answer
+	{0xff, 0xff, 0x00, 0x02, 0x09, 0x01, 0x02, 0x01, 0x01, 0x2a, 0x31, 0x10, 0x02, 0x82}, // Version answer
+	{0xff, 0xff, 0x00, 0x03, 0x04, 0x10, 0x20, 0x30, 0x98},                               // GetRGBLED answer
+	{0xff, 0xff, 0x07, 0x04, 0x01, 0xf3},                                                 // EPARAM
+	{0xff, 0xfe, 0x01, 0x00, 0x02, 0x03, 0xf9},                                           // Battery low
+	{0xff, 0xfe, 0x05, 0x00, 0x01, 0xf9},                                                 // Pre-sleep warning
+	{0xff, 0xfe, 0x03, 0x00, 0x05, 0x00, 0x64, 0xff, 0xfb, 0x99},                         // Streaming, 2 samples
+}
+
+func FuzzParseResponse(f *testing.F) {
+	for _, seed := range responseSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		checkParse(t, ParseResponse, buf)
+	})
+}
+
+func FuzzParseCommand(f *testing.F) {
+	f.Add([]byte{0xff, 0xff, 0x00, 0x01, 0x01, 0x01, 0xfc})                         // Ping
+	f.Add([]byte{0xff, 0xfe, 0x02, 0x20, 0x00, 0x05, 0x10, 0x20, 0x30, 0x01, 0x77}) // SetRGBLEDOutput without answer
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		checkParse(t, ParseCommand, buf)
+	})
+}
+
+// Checks the invariants of a parse function on arbitrary input.
+func checkParse(t *testing.T, parse func([]byte) (Packet, int, error), buf []byte) {
+	p, n, err := parse(buf)
+	if n < 0 || n > len(buf) {
+		t.Fatalf("Used %d of %d bytes", n, len(buf))
+	}
+	if err != nil {
+		var frameErr *FrameError
+		if !errors.As(err, &frameErr) || n == 0 || p != nil {
+			t.Fatalf("Malformed frames must skip bytes with a *FrameError but got %#v, %d, %v", p, n, err)
+		}
+		return
+	}
+	if p == nil {
+		if n != 0 {
+			t.Fatalf("Used %d bytes without a packet", n)
+		}
+		return
+	}
+
+	// Whatever parses must encode back to the same bytes.
+	frame, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(frame, buf[:n]) {
+		t.Fatalf("Parsed % x but encodes to % x", buf[:n], frame)
+	}
+}
+
+func FuzzDecoder(f *testing.F) {
+	var stream []byte
+	for _, seed := range responseSeeds {
+		f.Add(seed, uint8(0))
+		stream = append(stream, seed...)
+	}
+	f.Add(stream, uint8(1))
+	f.Add(append([]byte{0x00, 0xff, 0x12}, stream...), uint8(5))
+	f.Fuzz(func(t *testing.T, data []byte, chunk uint8) {
+		whole, wholeStats, left := decodeAll(t, bytes.NewReader(data))
+
+		// Decoding mustn't depend on how the bytes arrive.
+		r := &chunkReader{}
+		for i, size := 0, int(chunk%16)+1; i < len(data); i += size {
+			r.chunks = append(r.chunks, data[i:min(i+size, len(data))], nil)
+		}
+		split, splitStats, _ := decodeAll(t, r)
+		if !reflect.DeepEqual(whole, split) || wholeStats != splitStats {
+			t.Fatalf("Decoded differently in %d byte reads:\n%v %+v\n%v %+v", int(chunk%16)+1, whole, wholeStats, split, splitStats)
+		}
+
+		// Every byte is decoded, discarded or still buffered.
+		used := left + int(wholeStats.Discarded)
+		for _, r := range whole {
+			used += r.n
+		}
+		if used != len(data) {
+			t.Fatalf("Accounted for %d of %d bytes", used, len(data))
+		}
+	})
+}
+
+// A packet or framing error, with the length of a packet's frame.
+type decoded struct {
+	p   Packet
+	err error
+	n   int
+}
+
+// Decodes until the reader runs out, returning what was decoded, the stats
+// and the bytes left buffered.
+func decodeAll(t *testing.T, r io.Reader) ([]decoded, Stats, int) {
+	dec := NewDecoder(r)
+	var out []decoded
+	for {
+		p, err := dec.Decode()
+		if err == io.EOF {
+			if r, ok := r.(*chunkReader); ok && len(r.chunks) > 0 {
+				continue // A serial timeout
+			}
+			return out, dec.Stats(), dec.Buffered()
+		}
+		var frameErr *FrameError
+		switch {
+		case errors.As(err, &frameErr):
+			out = append(out, decoded{err: frameErr.Err})
+		case err != nil:
+			t.Fatal(err)
+		default:
+			frame, _ := p.MarshalBinary()
+			out = append(out, decoded{p: p, n: len(frame)})
+		}
+	}
+}
+
+func TestCommandRoundTrip(t *testing.T) {
+	// Every DID and CID, with any SOP2 and data that fits.
+	f := func(sop2, did, cid, seq uint8, data []byte) bool {
+		p := &CommandPacket{Sop2: 0xfc | sop2&0x03, Did: did, Cid: cid, Seq: seq, Data: data[:min(len(data), MaxCommandData)]}
+		frame, err := p.MarshalBinary()
+		if err != nil {
+			return false
+		}
+		got, n, err := ParseCommand(frame)
+		if err != nil || n != len(frame) {
+			return false
+		}
+		c := got.(*CommandPacket)
+		return c.Sop2 == p.Sop2 && c.Did == p.Did && c.Cid == p.Cid && c.Seq == p.Seq && bytes.Equal(c.Data, p.Data)
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 5000}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResponseRoundTrip(t *testing.T) {
+	answer := func(mrsp, seq uint8, data []byte) bool {
+		p := &AnswerPacket{Mrsp: mrsp, Seq: seq, Data: data[:min(len(data), MaxAnswerData)]}
+		return roundTrip(p)
+	}
+	if err := quick.Check(answer, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	async := func(id uint8, size uint16) bool {
+		// Defined async IDs, with lengths they can carry.
+		id = id%0x0c + 1
+		l := asyncLimits[id]
+		n := l.min + (int(size)%(l.max-l.min+1))/l.step*l.step
+		data := make([]byte, n)
+		rand.Read(data)
+		return roundTrip(&AsyncPacket{IdCode: id, Data: data})
+	}
+	if err := quick.Check(async, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Reports whether a packet decodes to itself.
+func roundTrip(p Packet) bool {
+	frame, err := p.MarshalBinary()
+	if err != nil {
+		return false
+	}
+	got, n, err := ParseResponse(frame)
+	if err != nil || n != len(frame) {
+		return false
+	}
+	switch p := p.(type) {
+	case *AnswerPacket:
+		a, ok := got.(*AnswerPacket)
+		return ok && a.Mrsp == p.Mrsp && a.Seq == p.Seq && bytes.Equal(a.Data, p.Data)
+	case *AsyncPacket:
+		a, ok := got.(*AsyncPacket)
+		return ok && a.IdCode == p.IdCode && bytes.Equal(a.Data, p.Data)
+	}
+	return false
+}
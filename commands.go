@@ -0,0 +1,548 @@
+package sphero
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// FieldType is the wire encoding of a payload field. Multi-byte fields are
+// big endian.
+type FieldType int
+
+const (
+	Uint8 FieldType = iota
+	Uint16
+	Uint32
+	Int16
+	Int32
+	Bool  // A byte, 0x00 or 0x01
+	Bytes // Everything left in the payload
+)
+
+func (t FieldType) size() int {
+	switch t {
+	case Uint8, Bool:
+		return 1
+	case Uint16, Int16:
+		return 2
+	case Uint32, Int32:
+		return 4
+	}
+	return 0
+}
+
+func (t FieldType) String() string {
+	switch t {
+	case Uint8:
+		return "uint8"
+	case Uint16:
+		return "uint16"
+	case Uint32:
+		return "uint32"
+	case Int16:
+		return "int16"
+	case Int32:
+		return "int32"
+	case Bool:
+		return "bool"
+	case Bytes:
+		return "bytes"
+	}
+	return fmt.Sprintf("FieldType(%d)", int(t))
+}
+
+// Field describes a field of a command, answer or async payload.
+type Field struct {
+	Name string
+	Type FieldType
+
+	// Inclusive range of valid values, checked if Max > Min.
+	Min, Max int64
+
+	// The field may be left off the end of the payload, e.g. for firmware
+	// that predates it.
+	Optional bool
+
+	// The oldest firmware with the field where the API documentation states
+	// it, zero if none is known.
+	MinFirmware Firmware
+}
+
+// Firmware is a main application (MSA) firmware version. See Version.
+type Firmware struct {
+	Major, Minor uint8
+}
+
+func (f Firmware) String() string {
+	return fmt.Sprintf("%d.%d", f.Major, f.Minor)
+}
+
+// Less reports whether `f` predates `o`.
+func (f Firmware) Less(o Firmware) bool {
+	return f.Major < o.Major || f.Major == o.Major && f.Minor < o.Minor
+}
+
+// Command describes a command and its answer.
+type Command struct {
+	Did, Cid uint8
+	Name     string  // As in const.go, e.g. "CMD_ROLL"
+	Request  []Field // Command payload
+	Response []Field // Answer payload
+	Async    []uint8 // Async IDs the command can cause the Sphero to send
+}
+
+// AsyncType describes an async packet.
+type AsyncType struct {
+	Id          uint8
+	Name        string // As in const.go, e.g. "ID_COLLISION_DETECTED"
+	Fields      []Field
+	MinFirmware Firmware // See Field
+}
+
+// Shorthands for the tables below.
+func u8(name string) Field   { return Field{Name: name, Type: Uint8} }
+func u16(name string) Field  { return Field{Name: name, Type: Uint16} }
+func u32(name string) Field  { return Field{Name: name, Type: Uint32} }
+func i16(name string) Field  { return Field{Name: name, Type: Int16} }
+func i32(name string) Field  { return Field{Name: name, Type: Int32} }
+func flag(name string) Field { return Field{Name: name, Type: Bool} }
+func raw(name string) Field  { return Field{Name: name, Type: Bytes} }
+
+func ranged(f Field, min, max int64) Field {
+	f.Min, f.Max = min, max
+	return f
+}
+
+func optional(f Field) Field {
+	f.Optional = true
+	return f
+}
+
+func since(f Field, major, minor uint8) Field {
+	f.MinFirmware = Firmware{major, minor}
+	return f
+}
+
+var commands = []*Command{
+	// Core
+	{Did: DID_CORE, Cid: CMD_PING, Name: "CMD_PING"},
+	{Did: DID_CORE, Cid: CMD_VERSION, Name: "CMD_VERSION",
+		Response: []Field{u8("recv"), u8("mdl"), u8("hw"), u8("msa_ver"), u8("msa_rev"), u8("bl"), u8("bas"), u8("macro"),
+			optional(u8("api_maj")), optional(u8("api_min"))}}, // Record version 2 and later
+	{Did: DID_CORE, Cid: CMD_CONTROL_UART_TX, Name: "CMD_CONTROL_UART_TX",
+		Request: []Field{flag("enable")}},
+	{Did: DID_CORE, Cid: CMD_SET_BT_NAME, Name: "CMD_SET_BT_NAME",
+		Request: []Field{raw("name")}},
+	{Did: DID_CORE, Cid: CMD_GET_BT_NAME, Name: "CMD_GET_BT_NAME",
+		Response: []Field{raw("info")}},
+	{Did: DID_CORE, Cid: CMD_SET_AUTO_RECONNECT, Name: "CMD_SET_AUTO_RECONNECT",
+		Request: []Field{flag("enable"), u8("time")}},
+	{Did: DID_CORE, Cid: CMD_GET_AUTO_RECONNECT, Name: "CMD_GET_AUTO_RECONNECT",
+		Response: []Field{flag("enable"), u8("time")}},
+	{Did: DID_CORE, Cid: CMD_GET_PWR_STATE, Name: "CMD_GET_PWR_STATE",
+		Response: []Field{u8("rec_ver"), u8("power_state"), u16("batt_voltage"), u16("num_charges"), u16("time_since_chg")}},
+	{Did: DID_CORE, Cid: CMD_SET_PWR_NOTIFY, Name: "CMD_SET_PWR_NOTIFY",
+		Request: []Field{flag("enable")},
+		Async:   []uint8{ID_POWER_NOTIFICATIONS}},
+	{Did: DID_CORE, Cid: CMD_SLEEP, Name: "CMD_SLEEP",
+		Request: []Field{u16("wakeup"), u8("macro"), u16("orb_basic")}},
+	{Did: DID_CORE, Cid: GET_POWER_TRIPS, Name: "GET_POWER_TRIPS",
+		Response: []Field{u16("low"), u16("critical")}},
+	{Did: DID_CORE, Cid: SET_POWER_TRIPS, Name: "SET_POWER_TRIPS",
+		Request: []Field{u16("low"), u16("critical")}},
+	{Did: DID_CORE, Cid: SET_INACTIVE_TIMER, Name: "SET_INACTIVE_TIMER",
+		Request: []Field{u16("time")},
+		Async:   []uint8{ID_PRE_SLEEP_WARNING}},
+	{Did: DID_CORE, Cid: CMD_GOTO_BL, Name: "CMD_GOTO_BL"},
+	{Did: DID_CORE, Cid: CMD_RUN_L1_DIAGS, Name: "CMD_RUN_L1_DIAGS",
+		Async: []uint8{ID_LEVEL_1_DIAGNOSTIC_RESPONSE}},
+	{Did: DID_CORE, Cid: CMD_RUN_L2_DIAGS, Name: "CMD_RUN_L2_DIAGS",
+		Response: []Field{raw("counters")}},
+	{Did: DID_CORE, Cid: CMD_CLEAR_COUNTERS, Name: "CMD_CLEAR_COUNTERS"},
+	{Did: DID_CORE, Cid: CMD_ASSIGN_TIME, Name: "CMD_ASSIGN_TIME",
+		Request: []Field{u32("counter")}},
+	{Did: DID_CORE, Cid: CMD_POLL_TIMES, Name: "CMD_POLL_TIMES",
+		Request:  []Field{u32("tx_time")},
+		Response: []Field{u32("tx_time"), u32("sphero_rx_time"), u32("sphero_tx_time")}},
+
+	// Bootloader
+	{Did: DID_BOOTLOADER, Cid: BEGIN_REFLASH, Name: "BEGIN_REFLASH"},
+	{Did: DID_BOOTLOADER, Cid: HERE_IS_PAGE, Name: "HERE_IS_PAGE",
+		Request: []Field{raw("page")}},
+	{Did: DID_BOOTLOADER, Cid: LEAVE_BOOTLOADER, Name: "LEAVE_BOOTLOADER"},
+	{Did: DID_BOOTLOADER, Cid: IS_PAGE_BLANK, Name: "IS_PAGE_BLANK",
+		Request:  []Field{u8("page")},
+		Response: []Field{flag("blank")}},
+	{Did: DID_BOOTLOADER, Cid: CMD_ERASE_USER_CONFIG, Name: "CMD_ERASE_USER_CONFIG"},
+
+	// Sphero
+	{Did: DID_SPHERO, Cid: CMD_SET_CAL, Name: "CMD_SET_CAL",
+		Request: []Field{ranged(u16("heading"), 0, 359)}},
+	{Did: DID_SPHERO, Cid: CMD_SET_STABILIZ, Name: "CMD_SET_STABILIZ",
+		Request: []Field{flag("enable")}},
+	{Did: DID_SPHERO, Cid: CMD_SET_ROTATION_RATE, Name: "CMD_SET_ROTATION_RATE",
+		Request: []Field{u8("rate")}},
+	{Did: DID_SPHERO, Cid: CMD_SET_BALL_REG_WEBSITE, Name: "CMD_SET_BALL_REG_WEBSITE",
+		Request: []Field{raw("url")}},
+	{Did: DID_SPHERO, Cid: CMD_GET_BALL_REG_WEBSITE, Name: "CMD_GET_BALL_REG_WEBSITE",
+		Response: []Field{raw("url")}},
+	{Did: DID_SPHERO, Cid: CMD_REENABLE_DEMO, Name: "CMD_REENABLE_DEMO"},
+	{Did: DID_SPHERO, Cid: CMD_GET_CHASSIS_ID, Name: "CMD_GET_CHASSIS_ID",
+		Response: []Field{u16("chassis_id")}},
+	{Did: DID_SPHERO, Cid: CMD_SET_CHASSIS_ID, Name: "CMD_SET_CHASSIS_ID",
+		Request: []Field{u16("chassis_id")}},
+	{Did: DID_SPHERO, Cid: CMD_SELF_LEVEL, Name: "CMD_SELF_LEVEL",
+		Request: []Field{ranged(u8("options"), 0, 0x0f), ranged(u8("angle_limit"), 0, 90), u8("timeout"), u8("true_time")},
+		Async:   []uint8{ID_SELF_LEVEL_RESULT}},
+	{Did: DID_SPHERO, Cid: CMD_SET_VDL, Name: "CMD_SET_VDL",
+		Request: []Field{raw("data")}},
+	{Did: DID_SPHERO, Cid: CMD_SET_DATA_STREAMING, Name: "CMD_SET_DATA_STREAMING",
+		Request: []Field{u16("n"), u16("m"), u32("mask"), u8("pcnt"), optional(since(u32("mask2"), 1, 17))},
+		Async:   []uint8{ID_SENSOR_DATA_STREAMING}},
+	{Did: DID_SPHERO, Cid: CMD_SET_COLLISION_DET, Name: "CMD_SET_COLLISION_DET",
+		Request: []Field{u8("method"), u8("x_threshold"), u8("x_speed"), u8("y_threshold"), u8("y_speed"), u8("dead_time")},
+		Async:   []uint8{ID_COLLISION_DETECTED}},
+	{Did: DID_SPHERO, Cid: CMD_LOCATOR, Name: "CMD_LOCATOR",
+		Request: []Field{u8("flags"), i16("x"), i16("y"), i16("yaw_tare")}},
+	{Did: DID_SPHERO, Cid: CMD_SET_ACCELERO, Name: "CMD_SET_ACCELERO",
+		Request: []Field{ranged(u8("range"), 0, 3)}},
+	{Did: DID_SPHERO, Cid: CMD_READ_LOCATOR, Name: "CMD_READ_LOCATOR",
+		Response: []Field{i16("x_pos"), i16("y_pos"), i16("x_vel"), i16("y_vel"), u16("sog")}},
+	{Did: DID_SPHERO, Cid: CMD_SET_RGB_LED, Name: "CMD_SET_RGB_LED",
+		Request: []Field{u8("red"), u8("green"), u8("blue"), flag("user")}},
+	{Did: DID_SPHERO, Cid: CMD_SET_BACK_LED, Name: "CMD_SET_BACK_LED",
+		Request: []Field{u8("brightness")}},
+	{Did: DID_SPHERO, Cid: CMD_GET_RGB_LED, Name: "CMD_GET_RGB_LED",
+		Response: []Field{u8("red"), u8("green"), u8("blue")}},
+	{Did: DID_SPHERO, Cid: CMD_ROLL, Name: "CMD_ROLL",
+		Request: []Field{u8("speed"), ranged(u16("heading"), 0, 359), ranged(u8("state"), 0, 2)}},
+	{Did: DID_SPHERO, Cid: CMD_BOOST, Name: "CMD_BOOST",
+		Request: []Field{ranged(u8("time"), 1, 255), ranged(u16("heading"), 0, 359)}},
+	{Did: DID_SPHERO, Cid: CMD_MOVE, Name: "CMD_MOVE",
+		Request: []Field{raw("data")}},
+	{Did: DID_SPHERO, Cid: CMD_SET_RAW_MOTORS, Name: "CMD_SET_RAW_MOTORS",
+		Request: []Field{ranged(u8("left_mode"), 0, 4), u8("left_power"), ranged(u8("right_mode"), 0, 4), u8("right_power")}},
+	{Did: DID_SPHERO, Cid: CMD_SET_MOTION_TO, Name: "CMD_SET_MOTION_TO",
+		Request: []Field{u16("time")}},
+	{Did: DID_SPHERO, Cid: CMD_SET_OPTIONS_FLAG, Name: "CMD_SET_OPTIONS_FLAG",
+		Request: []Field{u32("flags")}},
+	{Did: DID_SPHERO, Cid: CMD_GET_OPTIONS_FLAG, Name: "CMD_GET_OPTIONS_FLAG",
+		Response: []Field{u32("flags")}},
+	{Did: DID_SPHERO, Cid: CMD_SET_TEMP_OPTIONS_FLAG, Name: "CMD_SET_TEMP_OPTIONS_FLAG",
+		Request: []Field{u32("flags")}},
+	{Did: DID_SPHERO, Cid: CMD_GET_TEMP_OPTIONS_FLAG, Name: "CMD_GET_TEMP_OPTIONS_FLAG",
+		Response: []Field{u32("flags")}},
+	{Did: DID_SPHERO, Cid: CMD_GET_CONFIG_BLK, Name: "CMD_GET_CONFIG_BLK",
+		Request: []Field{u8("block")},
+		Async:   []uint8{ID_CONFIG_BLOCK_CONTENTS}},
+	{Did: DID_SPHERO, Cid: CMD_SET_DEVICE_MODE, Name: "CMD_SET_DEVICE_MODE",
+		Request: []Field{u8("mode")}},
+	{Did: DID_SPHERO, Cid: CMD_SET_CFG_BLOCK, Name: "CMD_SET_CFG_BLOCK",
+		Request: []Field{raw("block")}},
+	{Did: DID_SPHERO, Cid: CMD_GET_DEVICE_MODE, Name: "CMD_GET_DEVICE_MODE",
+		Response: []Field{u8("mode")}},
+	{Did: DID_SPHERO, Cid: CMD_RUN_MACRO, Name: "CMD_RUN_MACRO",
+		Request: []Field{u8("id")},
+		Async:   []uint8{ID_MACRO_MARKERS}},
+	{Did: DID_SPHERO, Cid: CMD_SAVE_TEMP_MACRO, Name: "CMD_SAVE_TEMP_MACRO",
+		Request: []Field{raw("macro")}},
+	{Did: DID_SPHERO, Cid: CMD_SAVE_MACRO, Name: "CMD_SAVE_MACRO",
+		Request: []Field{raw("macro")}},
+	{Did: DID_SPHERO, Cid: CMD_INIT_MACRO_EXECUTIVE, Name: "CMD_INIT_MACRO_EXECUTIVE"},
+	{Did: DID_SPHERO, Cid: CMD_ABORT_MACRO, Name: "CMD_ABORT_MACRO",
+		Response: []Field{u8("id"), u16("cmd_num")}},
+	{Did: DID_SPHERO, Cid: CMD_MACRO_STATUS, Name: "CMD_MACRO_STATUS",
+		Response: []Field{u8("id"), u16("cmd_num")}},
+	{Did: DID_SPHERO, Cid: CMD_SET_MACRO_PARAM, Name: "CMD_SET_MACRO_PARAM",
+		Request: []Field{u8("param"), u8("val1"), optional(u8("val2"))}},
+	{Did: DID_SPHERO, Cid: CMD_APPEND_TEMP_MACRO_CHUNK, Name: "CMD_APPEND_TEMP_MACRO_CHUNK",
+		Request: []Field{raw("chunk")}},
+	{Did: DID_SPHERO, Cid: CMD_ERASE_ORBBAS, Name: "CMD_ERASE_ORBBAS",
+		Request: []Field{u8("area")}},
+	{Did: DID_SPHERO, Cid: CMD_APPEND_FRAG, Name: "CMD_APPEND_FRAG",
+		Request: []Field{u8("area"), raw("fragment")}},
+	{Did: DID_SPHERO, Cid: CMD_EXEC_ORBBAS, Name: "CMD_EXEC_ORBBAS",
+		Request: []Field{u8("area"), u16("start_line")},
+		Async:   []uint8{ID_ORBBAS_PRINT, ID_ORBBAS_ERROR_ASCII, ID_ORBBAS_ERROR_BINARY}},
+	{Did: DID_SPHERO, Cid: CMD_ABORT_ORBBAS, Name: "CMD_ABORT_ORBBAS"},
+	{Did: DID_SPHERO, Cid: CMD_ANSWER_INPUT, Name: "CMD_ANSWER_INPUT",
+		Request: []Field{i32("value")}},
+}
+
+var asyncTypes = []*AsyncType{
+	{Id: ID_POWER_NOTIFICATIONS, Name: "ID_POWER_NOTIFICATIONS",
+		Fields: []Field{u8("power_state")}},
+	{Id: ID_LEVEL_1_DIAGNOSTIC_RESPONSE, Name: "ID_LEVEL_1_DIAGNOSTIC_RESPONSE",
+		Fields: []Field{raw("text")}},
+	{Id: ID_SENSOR_DATA_STREAMING, Name: "ID_SENSOR_DATA_STREAMING",
+		Fields: []Field{raw("samples")}},
+	{Id: ID_CONFIG_BLOCK_CONTENTS, Name: "ID_CONFIG_BLOCK_CONTENTS",
+		Fields: []Field{raw("block")}},
+	{Id: ID_PRE_SLEEP_WARNING, Name: "ID_PRE_SLEEP_WARNING"},
+	{Id: ID_MACRO_MARKERS, Name: "ID_MACRO_MARKERS",
+		Fields: []Field{u8("marker"), u8("id"), u16("cmd_num")}},
+	{Id: ID_COLLISION_DETECTED, Name: "ID_COLLISION_DETECTED",
+		Fields: []Field{i16("x"), i16("y"), i16("z"), u8("axis"), i16("x_mag"), i16("y_mag"), u8("speed"), u32("timestamp")}},
+	{Id: ID_ORBBAS_PRINT, Name: "ID_ORBBAS_PRINT",
+		Fields: []Field{raw("text")}},
+	{Id: ID_ORBBAS_ERROR_ASCII, Name: "ID_ORBBAS_ERROR_ASCII",
+		Fields: []Field{raw("text")}},
+	{Id: ID_ORBBAS_ERROR_BINARY, Name: "ID_ORBBAS_ERROR_BINARY",
+		Fields: []Field{u16("error"), u16("line")}},
+	{Id: ID_SELF_LEVEL_RESULT, Name: "ID_SELF_LEVEL_RESULT",
+		Fields: []Field{u8("result")}},
+	{Id: ID_GYRO_AXIS_LIMIT_EXCEEDED, Name: "ID_GYRO_AXIS_LIMIT_EXCEEDED",
+		Fields:      []Field{u8("axes")},
+		MinFirmware: Firmware{3, 10}},
+}
+
+var (
+	commandsByKey  = make(map[uint16]*Command, len(commands))
+	asyncTypesById = make(map[uint8]*AsyncType, len(asyncTypes))
+)
+
+func init() {
+	for _, c := range commands {
+		commandsByKey[cmdKey(c.Did, c.Cid)] = c
+	}
+	for _, a := range asyncTypes {
+		asyncTypesById[a.Id] = a
+	}
+}
+
+// Commands returns every known command, ordered by DID then CID.
+func Commands() []*Command {
+	out := append([]*Command(nil), commands...)
+	sort.Slice(out, func(i, j int) bool {
+		return cmdKey(out[i].Did, out[i].Cid) < cmdKey(out[j].Did, out[j].Cid)
+	})
+	return out
+}
+
+// LookupCommand returns the descriptor of a command, if it's known.
+func LookupCommand(did, cid uint8) (*Command, bool) {
+	c, ok := commandsByKey[cmdKey(did, cid)]
+	return c, ok
+}
+
+// LookupAsync returns the descriptor of an async packet, if it's known.
+func LookupAsync(id uint8) (*AsyncType, bool) {
+	a, ok := asyncTypesById[id]
+	return a, ok
+}
+
+// Returns the descriptor of a command this package sends. It panics if the
+// command is missing from the table.
+func mustCommand(did, cid uint8) *Command {
+	c, ok := LookupCommand(did, cid)
+	if !ok {
+		panic(fmt.Sprintf("sphero: no descriptor for %s %s", DeviceName(did), CommandName(did, cid)))
+	}
+	return c
+}
+
+// Encodes the payload of a command this package sends, from values whose
+// types guarantee they fit.
+func encode(did, cid uint8, values ...interface{}) []byte {
+	data, err := mustCommand(did, cid).EncodeRequest(values...)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// EncodeRequest encodes a command payload from one value per request field,
+// in order. Integer fields take any integer type, Bool fields a bool and
+// Bytes fields a []byte or string. Optional fields may be left off.
+func (c *Command) EncodeRequest(values ...interface{}) ([]byte, error) {
+	return encodeFields(c.Request, values)
+}
+
+// DecodeRequest decodes and validates a command payload.
+func (c *Command) DecodeRequest(data []byte) (Values, error) {
+	return decodeFields(c.Request, data)
+}
+
+// DecodeResponse decodes and validates an answer payload.
+func (c *Command) DecodeResponse(data []byte) (Values, error) {
+	return decodeFields(c.Response, data)
+}
+
+// Decode decodes and validates an async payload.
+func (a *AsyncType) Decode(data []byte) (Values, error) {
+	return decodeFields(a.Fields, data)
+}
+
+// Value is a decoded field. Value holds a uint8, uint16, uint32, int16,
+// int32, bool or []byte according to the field type.
+type Value struct {
+	Field
+	Value interface{}
+}
+
+// Values are the decoded fields of a payload, in order.
+type Values []Value
+
+// Get returns the value of the named field, or nil.
+func (vs Values) Get(name string) interface{} {
+	for _, v := range vs {
+		if v.Name == name {
+			return v.Value
+		}
+	}
+	return nil
+}
+
+// String formats the values as "name=value" pairs, e.g.
+// "speed=128 heading=90 state=1".
+func (vs Values) String() string {
+	parts := make([]string, len(vs))
+	for i, v := range vs {
+		switch x := v.Value.(type) {
+		case []byte:
+			parts[i] = fmt.Sprintf("%s=%x", v.Name, x)
+		default:
+			parts[i] = fmt.Sprintf("%s=%v", v.Name, x)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func encodeFields(fields []Field, values []interface{}) ([]byte, error) {
+	required := 0
+	for _, f := range fields {
+		if !f.Optional {
+			required++
+		}
+	}
+	if len(values) < required || len(values) > len(fields) {
+		return nil, fmt.Errorf("%w: expected %d values but got %d", PayloadLayoutError, len(fields), len(values))
+	}
+
+	var data []byte
+	for i, v := range values {
+		f := fields[i]
+		if f.Type == Bytes {
+			switch b := v.(type) {
+			case []byte:
+				data = append(data, b...)
+			case string:
+				data = append(data, b...)
+			default:
+				return nil, fmt.Errorf("%w: %s must be []byte but got %T", PayloadLayoutError, f.Name, v)
+			}
+			continue
+		}
+
+		var n int64
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Bool:
+			if f.Type != Bool {
+				return nil, fmt.Errorf("%w: %s must be an integer but got %T", PayloadLayoutError, f.Name, v)
+			}
+			if rv.Bool() {
+				n = 1
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = rv.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			n = int64(rv.Uint())
+		default:
+			return nil, fmt.Errorf("%w: %s must be %s but got %T", PayloadLayoutError, f.Name, f.Type, v)
+		}
+		if err := f.check(n); err != nil {
+			return nil, err
+		}
+		data = appendField(data, f.Type, n)
+	}
+	return data, nil
+}
+
+// Checks an integer fits the field's type and range.
+func (f Field) check(n int64) error {
+	var lo, hi int64
+	switch f.Type {
+	case Uint8:
+		lo, hi = 0, 0xff
+	case Uint16:
+		lo, hi = 0, 0xffff
+	case Uint32:
+		lo, hi = 0, 0xffffffff
+	case Int16:
+		lo, hi = -1<<15, 1<<15-1
+	case Int32:
+		lo, hi = -1<<31, 1<<31-1
+	case Bool:
+		lo, hi = 0, 1
+	}
+	if f.Max > f.Min {
+		lo, hi = f.Min, f.Max
+	}
+	if n < lo || n > hi {
+		return fmt.Errorf("%w: %s is %d, must be between %d and %d", FieldRangeError, f.Name, n, lo, hi)
+	}
+	return nil
+}
+
+func appendField(data []byte, t FieldType, n int64) []byte {
+	switch t.size() {
+	case 1:
+		return append(data, uint8(n))
+	case 2:
+		return binary.BigEndian.AppendUint16(data, uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(data, uint32(n))
+	}
+}
+
+func decodeFields(fields []Field, data []byte) (Values, error) {
+	values := make(Values, 0, len(fields))
+	for _, f := range fields {
+		if f.Type == Bytes {
+			values = append(values, Value{Field: f, Value: data})
+			data = nil
+			continue
+		}
+		size := f.Type.size()
+		if len(data) == 0 && f.Optional {
+			break
+		}
+		if len(data) < size {
+			return values, fmt.Errorf("%w: payload ends before %s", PayloadLayoutError, f.Name)
+		}
+
+		var v interface{}
+		var n int64
+		switch f.Type {
+		case Uint8:
+			v, n = data[0], int64(data[0])
+		case Bool:
+			v, n = data[0] != 0, int64(data[0])
+		case Uint16:
+			x := binary.BigEndian.Uint16(data)
+			v, n = x, int64(x)
+		case Int16:
+			x := int16(binary.BigEndian.Uint16(data))
+			v, n = x, int64(x)
+		case Uint32:
+			x := binary.BigEndian.Uint32(data)
+			v, n = x, int64(x)
+		case Int32:
+			x := int32(binary.BigEndian.Uint32(data))
+			v, n = x, int64(x)
+		}
+		if err := f.check(n); err != nil {
+			return values, err
+		}
+		values = append(values, Value{Field: f, Value: v})
+		data = data[size:]
+	}
+	if len(data) > 0 {
+		return values, fmt.Errorf("%w: %d bytes left over", PayloadLayoutError, len(data))
+	}
+	return values, nil
+}
@@ -0,0 +1,127 @@
+package sphero_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/FreeFlow/sphero"
+)
+
+func TestCommandsComplete(t *testing.T) {
+	cmds := sphero.Commands()
+	if len(cmds) != 68 {
+		t.Errorf("Expected 68 commands but got %d", len(cmds))
+	}
+	for i, c := range cmds {
+		if i > 0 {
+			p := cmds[i-1]
+			if p.Did > c.Did || p.Did == c.Did && p.Cid >= c.Cid {
+				t.Errorf("%s out of order after %s", c.Name, p.Name)
+			}
+		}
+		if got, ok := sphero.LookupCommand(c.Did, c.Cid); !ok || got != c {
+			t.Errorf("LookupCommand doesn't find %s", c.Name)
+		}
+		if name := sphero.CommandName(c.Did, c.Cid); name != c.Name {
+			t.Errorf("Expected name %s but got %s", c.Name, name)
+		}
+		for _, id := range c.Async {
+			if _, ok := sphero.LookupAsync(id); !ok {
+				t.Errorf("%s triggers unknown async ID %#02x", c.Name, id)
+			}
+		}
+	}
+
+	for id := uint8(sphero.ID_POWER_NOTIFICATIONS); id <= sphero.ID_GYRO_AXIS_LIMIT_EXCEEDED; id++ {
+		if _, ok := sphero.LookupAsync(id); !ok {
+			t.Errorf("Missing async ID %#02x", id)
+		}
+	}
+}
+
+func TestEncodeRequest(t *testing.T) {
+	roll, _ := sphero.LookupCommand(sphero.DID_SPHERO, sphero.CMD_ROLL)
+
+	data, err := roll.EncodeRequest(128, uint16(270), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x80, 0x01, 0x0e, 0x01}; !bytes.Equal(data, want) {
+		t.Fatalf("Expected % x but got % x", want, data)
+	}
+
+	vs, err := roll.DecodeRequest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := vs.String(); got != "speed=128 heading=270 state=1" {
+		t.Fatalf("Unexpected values %s", got)
+	}
+	if h := vs.Get("heading"); h != uint16(270) {
+		t.Fatalf("Expected heading 270 but got %v", h)
+	}
+
+	cases := []struct {
+		values []interface{}
+		err    error
+	}{
+		{[]interface{}{128, 360, 1}, sphero.FieldRangeError},
+		{[]interface{}{256, 0, 1}, sphero.FieldRangeError},
+		{[]interface{}{128, 0, 3}, sphero.FieldRangeError},
+		{[]interface{}{128, 0}, sphero.PayloadLayoutError},
+		{[]interface{}{128, 0, 1, 0}, sphero.PayloadLayoutError},
+		{[]interface{}{128, "north", 1}, sphero.PayloadLayoutError},
+	}
+	for _, c := range cases {
+		if _, err := roll.EncodeRequest(c.values...); !errors.Is(err, c.err) {
+			t.Errorf("%v: expected %v but got %v", c.values, c.err, err)
+		}
+	}
+}
+
+func TestDecodeOptionalFields(t *testing.T) {
+	stream, _ := sphero.LookupCommand(sphero.DID_SPHERO, sphero.CMD_SET_DATA_STREAMING)
+
+	// Firmware before 1.17 has no second mask.
+	if f := stream.Request[4]; f.Name != "mask2" || f.MinFirmware != (sphero.Firmware{Major: 1, Minor: 17}) {
+		t.Fatalf("Expected mask2 to need firmware 1.17 but got %+v", f)
+	}
+	data := []byte{0x00, 0x0a, 0x00, 0x01, 0x00, 0x00, 0xe0, 0x00, 0x00}
+	vs, err := stream.DecodeRequest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vs) != 4 || vs.Get("mask2") != nil {
+		t.Fatalf("Expected no mask2 but got %s", vs)
+	}
+
+	if _, err := stream.DecodeRequest(append(data, 0x00, 0x00)); !errors.Is(err, sphero.PayloadLayoutError) {
+		t.Fatalf("Expected PayloadLayoutError for a truncated mask2 but got %v", err)
+	}
+	if _, err := stream.DecodeRequest(data[:5]); !errors.Is(err, sphero.PayloadLayoutError) {
+		t.Fatalf("Expected PayloadLayoutError for a truncated mask but got %v", err)
+	}
+}
+
+func TestDecodeAsync(t *testing.T) {
+	a, ok := sphero.LookupAsync(sphero.ID_COLLISION_DETECTED)
+	if !ok {
+		t.Fatal("Missing ID_COLLISION_DETECTED")
+	}
+	data := []byte{
+		0xff, 0xfe, 0x00, 0x10, 0x00, 0x00, // x, y, z
+		0x01,                   // axis
+		0x00, 0x20, 0x00, 0x00, // x_mag, y_mag
+		0x40,                   // speed
+		0x00, 0x00, 0x12, 0x34, // timestamp
+	}
+	vs, err := a.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "x=-2 y=16 z=0 axis=1 x_mag=32 y_mag=0 speed=64 timestamp=4660"
+	if got := vs.String(); got != want {
+		t.Fatalf("Expected %s but got %s", want, got)
+	}
+}
@@ -0,0 +1,68 @@
+package sphero_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FreeFlow/sphero"
+	"github.com/FreeFlow/sphero/spherosim"
+)
+
+// Run with -race.
+func TestConcurrentCommands(t *testing.T) {
+	sim := spherosim.New()
+	defer sim.Close()
+
+	s := sphero.NewSpheroConn(sim.Conn(), nil)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	want := sphero.Color{R: 1, G: 2, B: 3}
+	if err := s.SetRGBLEDOutputContext(ctx, want.R, want.G, want.B, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// More commands than there are seq numbers, from many goroutines, with
+	// both the synchronous and the channel API.
+	var wg sync.WaitGroup
+	errs := make(chan error, 16*40)
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			ch := make(chan *sphero.Response, 1)
+			for i := 0; i < 40; i++ {
+				if g%2 == 0 {
+					c, err := s.GetRGBLEDContext(ctx)
+					if err == nil && *c != want {
+						t.Errorf("Expected color %+v but got %+v", want, *c)
+					}
+					errs <- err
+					continue
+				}
+				if err := s.SetBackLEDOutput(uint8(i), ch); err != nil {
+					errs <- err
+					continue
+				}
+				select {
+				case r := <-ch:
+					errs <- r.Error()
+				case <-ctx.Done():
+					errs <- ctx.Err()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -1,12 +1,22 @@
 package sphero
 
+import (
+	"github.com/FreeFlow/sphero/codec"
+)
+
 // Start of Packet values
 const (
-	SOP1                     = 0xff
-	SOP2_ANSWER              = 0xff
-	SOP2_ASYNC               = 0xfe
-	SOP2_RESET_TIMEOUT       = 0xfd
-	SOP2_ASYNC_RESET_TIMEOUT = 0xfc
+	SOP1                     = codec.SOP1
+	SOP2_ANSWER              = codec.SOP2_ANSWER
+	SOP2_ASYNC               = codec.SOP2_ASYNC
+	SOP2_RESET_TIMEOUT       = codec.SOP2_RESET_TIMEOUT
+	SOP2_ASYNC_RESET_TIMEOUT = codec.SOP2_ASYNC_RESET_TIMEOUT
+)
+
+// Command SOP2 flags - a command's SOP2 is 0xfc plus any of these. See SendMode.
+const (
+	SOP2_FLAG_ANSWER        = 0x01 // Answer the command
+	SOP2_FLAG_RESET_TIMEOUT = 0x02 // Reset the client inactivity timeout
 )
 
 // Device IDs
@@ -131,6 +141,14 @@ const (
 	ID_GYRO_AXIS_LIMIT_EXCEEDED    = 0x0c // Gyro axis limit exceeded (FW ver 3.10 and later)
 )
 
+// Self Level Option Flags
+const (
+	SELF_LEVEL_START          = 0x01 // Start self leveling, otherwise abort it
+	SELF_LEVEL_FINAL_ANGLE    = 0x02 // Rotate to heading 0 when done
+	SELF_LEVEL_SLEEP          = 0x04 // Go to sleep when done
+	SELF_LEVEL_CONTROL_SYSTEM = 0x08 // Leave the control system on when done
+)
+
 // Battery
 const (
 	BATTERY_CHARGING = 0x01
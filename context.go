@@ -0,0 +1,128 @@
+package sphero
+
+import (
+	"context"
+	"time"
+)
+
+// Do sends a command and waits for its answer. It returns early with the
+// context's error if `ctx` is cancelled or its deadline passes, and with
+// the Response's error if the Sphero answered with anything other than
+// ORBOTIX_RSP_CODE_OK. The Response is returned along with MRSP errors so
+// callers can inspect it. With a mode that requests no answer Do returns nil
+// and no error as soon as the command is written.
+//
+// Nothing is sent if `ctx` is already done. The deadline of `ctx` also
+// bounds writing the command if the transport implements Deadliner.
+func (s *Sphero) Do(ctx context.Context, did, cid uint8, data []byte, mode ...SendMode) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m := sendMode(mode)
+	ch := make(chan *Response, 1)
+	deadline, ok := ctx.Deadline()
+	seq, err := s.send(did, cid, data, ch, m, deadline)
+	if err != nil && ok && !time.Now().Before(deadline) {
+		return nil, context.DeadlineExceeded // The write ran into the deadline
+	}
+	if err != nil || !m.Answer() {
+		return nil, err
+	}
+
+	select {
+	case r := <-ch:
+		return r, r.Error()
+	case <-ctx.Done():
+		s.release(seq, ch)
+		return nil, ctx.Err()
+	}
+}
+
+// Synchronous variants of the command methods. Each sends the command with
+// Do and waits for the answer.
+
+func (s *Sphero) PingContext(ctx context.Context, mode ...SendMode) error {
+	_, err := s.Do(ctx, DID_CORE, CMD_PING, nil, mode...)
+	return err
+}
+
+// Gets the versions of the Sphero's hardware and software.
+func (s *Sphero) GetVersionContext(ctx context.Context, mode ...SendMode) (*Version, error) {
+	if !sendMode(mode).Answer() {
+		return nil, AnswerRequiredError
+	}
+	r, err := s.Do(ctx, DID_CORE, CMD_VERSION, nil, mode...)
+	if err != nil {
+		return nil, err
+	}
+	return r.Version()
+}
+
+func (s *Sphero) SleepContext(ctx context.Context, wakeup time.Duration, macro uint8, orbBasic uint16, mode ...SendMode) error {
+	_, err := s.Do(ctx, DID_CORE, CMD_SLEEP, sleepData(wakeup, macro, orbBasic), mode...)
+	return err
+}
+
+// Gets the current power state of the device.
+func (s *Sphero) GetPowerStateContext(ctx context.Context, mode ...SendMode) (*PowerState, error) {
+	if !sendMode(mode).Answer() {
+		return nil, AnswerRequiredError
+	}
+	r, err := s.Do(ctx, DID_CORE, CMD_GET_PWR_STATE, nil, mode...)
+	if err != nil {
+		return nil, err
+	}
+	return r.PowerState()
+}
+
+func (s *Sphero) SetPowerNotificationContext(ctx context.Context, flag bool, mode ...SendMode) error {
+	_, err := s.Do(ctx, DID_CORE, CMD_SET_PWR_NOTIFY, powerNotificationData(flag), mode...)
+	return err
+}
+
+func (s *Sphero) SetHeadingContext(ctx context.Context, heading int16, mode ...SendMode) error {
+	data, err := headingData(heading)
+	if err != nil {
+		return err
+	}
+	_, err = s.Do(ctx, DID_SPHERO, CMD_SET_CAL, data, mode...)
+	return err
+}
+
+func (s *Sphero) SetStabilizationContext(ctx context.Context, flag bool, mode ...SendMode) error {
+	_, err := s.Do(ctx, DID_SPHERO, CMD_SET_STABILIZ, stabilizationData(flag), mode...)
+	return err
+}
+
+func (s *Sphero) SetDataStreamingContext(ctx context.Context, n, m int16, pcnt uint8, masks []uint32, masks2 []uint32, mode ...SendMode) error {
+	_, err := s.Do(ctx, DID_SPHERO, CMD_SET_DATA_STREAMING, dataStreamingData(n, m, pcnt, masks, masks2), mode...)
+	return err
+}
+
+func (s *Sphero) ConfigureCollisionDetectionContext(ctx context.Context, method, xThreshold, yThreshold, xSpeed, ySpeed, deadTime uint8, mode ...SendMode) error {
+	data := collisionDetectionData(method, xThreshold, yThreshold, xSpeed, ySpeed, deadTime)
+	_, err := s.Do(ctx, DID_SPHERO, CMD_SET_COLLISION_DET, data, mode...)
+	return err
+}
+
+func (s *Sphero) SetRGBLEDOutputContext(ctx context.Context, red, green, blue uint8, flag bool, mode ...SendMode) error {
+	_, err := s.Do(ctx, DID_SPHERO, CMD_SET_RGB_LED, rgbLEDData(red, green, blue, flag), mode...)
+	return err
+}
+
+func (s *Sphero) SetBackLEDOutputContext(ctx context.Context, brightness uint8, mode ...SendMode) error {
+	_, err := s.Do(ctx, DID_SPHERO, CMD_SET_BACK_LED, backLEDData(brightness), mode...)
+	return err
+}
+
+// Returns the "user LED color". The color displayed after a successful bluetooth connection.
+func (s *Sphero) GetRGBLEDContext(ctx context.Context, mode ...SendMode) (*Color, error) {
+	if !sendMode(mode).Answer() {
+		return nil, AnswerRequiredError
+	}
+	r, err := s.Do(ctx, DID_SPHERO, CMD_GET_RGB_LED, nil, mode...)
+	if err != nil {
+		return nil, err
+	}
+	return r.Color()
+}
@@ -0,0 +1,240 @@
+package sphero_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/FreeFlow/sphero"
+	"github.com/FreeFlow/sphero/spherosim"
+)
+
+// Connects to a new virtual Sphero, closing both once the test ends. Async
+// responses go to the returned channel.
+func connect(t *testing.T) (*spherosim.Sim, *sphero.Sphero, chan *sphero.AsyncResponse) {
+	sim := spherosim.New()
+	async := make(chan *sphero.AsyncResponse, 16)
+	s := sphero.NewSpheroConn(sim.Conn(), async)
+	t.Cleanup(func() {
+		s.Close()
+		sim.Close()
+	})
+	return sim, s, async
+}
+
+// Returns a context ending after `d` or with the test.
+func timeout(t *testing.T, d time.Duration) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestContextCommands(t *testing.T) {
+	_, s, _ := connect(t)
+	ctx := timeout(t, time.Second)
+
+	if err := s.PingContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetRGBLEDOutputContext(ctx, 1, 2, 3, true); err != nil {
+		t.Fatal(err)
+	}
+	c, err := s.GetRGBLEDContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *c != (sphero.Color{R: 1, G: 2, B: 3}) {
+		t.Fatalf("Unexpected color %#v", c)
+	}
+
+	ps, err := s.GetPowerStateContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.PowerState != sphero.BATTERY_OK {
+		t.Fatalf("Unexpected power state %#v", ps)
+	}
+
+	v, err := s.GetVersionContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sphero.Version{
+		RecVer:     0x01,
+		Model:      0x02,
+		Hardware:   0x01,
+		MSA:        sphero.Firmware{Major: 1, Minor: 42},
+		Bootloader: sphero.Firmware{Major: 3, Minor: 1},
+		OrbBasic:   sphero.Firmware{Major: 1, Minor: 0},
+		Macro:      0x02,
+	}
+	if *v != want {
+		t.Fatalf("Expected version %+v but got %+v", want, *v)
+	}
+}
+
+func TestResponseResult(t *testing.T) {
+	_, s, _ := connect(t)
+	ctx := timeout(t, time.Second)
+
+	r, err := s.Do(ctx, sphero.DID_CORE, sphero.CMD_GET_PWR_STATE, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := r.Result(); err != nil {
+		t.Fatal(err)
+	} else if ps, ok := v.(*sphero.PowerState); !ok || ps.PowerState != sphero.BATTERY_OK {
+		t.Fatalf("Expected a PowerState but got %#v", v)
+	}
+
+	// Parsing as another command's answer is a mismatch, not garbage.
+	if _, err := r.Color(); !errors.Is(err, sphero.ResponseMismatchError) {
+		t.Fatalf("Expected ResponseMismatchError but got %v", err)
+	}
+	if _, err := r.Version(); !errors.Is(err, sphero.ResponseMismatchError) {
+		t.Fatalf("Expected ResponseMismatchError but got %v", err)
+	}
+
+	r, err = s.Do(ctx, sphero.DID_CORE, sphero.CMD_PING, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := r.Result(); v != nil || err != nil {
+		t.Fatalf("Expected no result but got %#v, %v", v, err)
+	}
+}
+
+func TestResponseLayout(t *testing.T) {
+	r := &sphero.Response{Data: []byte{0x01, 0x02}}
+	if _, err := r.Color(); !errors.Is(err, sphero.PayloadLayoutError) {
+		t.Fatalf("Expected PayloadLayoutError but got %v", err)
+	}
+	if _, err := r.Result(); !errors.Is(err, sphero.UnboundResponseError) {
+		t.Fatalf("Expected UnboundResponseError but got %v", err)
+	}
+
+	r.Data = []byte{0x0a, 0x0b, 0x0c}
+	if c, err := r.Color(); err != nil || *c != (sphero.Color{R: 0x0a, G: 0x0b, B: 0x0c}) {
+		t.Fatalf("Unexpected color %#v, %v", c, err)
+	}
+}
+
+func TestVersionAPI(t *testing.T) {
+	// Record version 2 adds the API version.
+	r := &sphero.Response{Data: []byte{0x02, 0x02, 0x01, 0x01, 0x2a, 0x31, 0x10, 0x02, 0x01, 0x32}}
+	v, err := r.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.RecVer != 0x02 || v.MSA != (sphero.Firmware{Major: 1, Minor: 42}) || v.API != (sphero.Firmware{Major: 1, Minor: 50}) {
+		t.Fatalf("Unexpected version %+v", *v)
+	}
+}
+
+func TestDoReturnsResponseErrors(t *testing.T) {
+	_, s, _ := connect(t)
+
+	r, err := s.Do(context.Background(), sphero.DID_SPHERO, sphero.CMD_SET_BACK_LED, []byte{1, 2})
+	if err != sphero.InvalidParametersError {
+		t.Fatalf("Expected InvalidParametersError but got %v", err)
+	}
+	if r == nil || r.Mrsp != sphero.ORBOTIX_RSP_CODE_EPARAM {
+		t.Fatalf("Expected the failed response but got %#v", r)
+	}
+}
+
+func TestContextNoAnswer(t *testing.T) {
+	sim := spherosim.New()
+	defer sim.Close()
+
+	client, device := net.Pipe()
+	defer device.Close()
+	go func() {
+		// Forward commands to the simulator but never deliver its answers.
+		conn := sim.Conn()
+		go io.Copy(io.Discard, conn)
+		io.Copy(conn, device)
+	}()
+
+	s := sphero.NewSpheroConn(client, nil)
+	defer s.Close()
+
+	ctx := timeout(t, time.Second)
+
+	if err := s.SetRGBLEDOutputContext(ctx, 1, 2, 3, false, sphero.ModeNoAnswer); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PingContext(ctx, sphero.ModeNoAnswerNoReset); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetRGBLEDContext(ctx, sphero.ModeNoAnswer); err != sphero.AnswerRequiredError {
+		t.Fatalf("Expected AnswerRequiredError but got %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for sim.State().Color != (sphero.Color{R: 1, G: 2, B: 3}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Simulator never applied the color, state %#v", sim.State())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDoHonorsDeadline(t *testing.T) {
+	client, device := net.Pipe()
+	defer device.Close()
+	go io.Copy(io.Discard, device) // Never answers
+
+	s := sphero.NewSpheroConn(client, nil)
+	defer s.Close()
+
+	ctx := timeout(t, 20*time.Millisecond)
+
+	if err := s.PingContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded but got %v", err)
+	}
+}
+
+func TestDoDoneContext(t *testing.T) {
+	client, device := net.Pipe()
+	defer device.Close()
+
+	s := sphero.NewSpheroConn(client, nil)
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.RollContext(ctx, 0x80, 90, sphero.RollNormal, sphero.ModeNoAnswer); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled but got %v", err)
+	}
+
+	device.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if n, err := device.Read(make([]byte, 16)); n != 0 || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Expected nothing sent but read %d bytes, %v", n, err)
+	}
+}
+
+func TestDoWriteDeadline(t *testing.T) {
+	client, device := net.Pipe() // Nothing reads, so writes stall
+	defer device.Close()
+
+	s := sphero.NewSpheroConn(client, nil)
+	defer s.Close()
+
+	ctx := timeout(t, 20*time.Millisecond)
+	errc := make(chan error, 1)
+	go func() { errc <- s.PingContext(ctx) }()
+
+	select {
+	case err := <-errc:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("Expected context.DeadlineExceeded but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Write ignored the context deadline")
+	}
+}
@@ -0,0 +1,252 @@
+package sphero
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// RollState is the drive state of a Roll command.
+type RollState uint8
+
+const (
+	RollStop   RollState = 0x00 // Come to a stop, holding the heading
+	RollNormal RollState = 0x01 // Drive at the speed and heading
+	RollFast   RollState = 0x02 // Turn to the heading as fast as possible, then drive
+)
+
+func (r RollState) String() string {
+	switch r {
+	case RollStop:
+		return "stop"
+	case RollNormal:
+		return "normal"
+	case RollFast:
+		return "fast"
+	}
+	return fmt.Sprintf("RollState(%d)", uint8(r))
+}
+
+// Roll drives at `speed`, 0 to 255, towards `heading` in degrees, 0 to 359,
+// clockwise from the heading set with SetHeading. Without stabilization the
+// Sphero can't hold a heading, see SetStabilization.
+//
+// Roll is typically sent many times a second while steering, where sending
+// with ModeNoAnswer saves an acknowledgement per command.
+func (s *Sphero) Roll(speed uint8, heading uint16, state RollState, res chan<- *Response, mode ...SendMode) error {
+	data, err := s.rollData(speed, heading, state)
+	if err != nil {
+		return err
+	}
+	return s.Send(DID_SPHERO, CMD_ROLL, data, res, mode...)
+}
+
+// Stop brings the Sphero to a stop, holding the heading of the last Roll so
+// it doesn't turn as it stops.
+func (s *Sphero) Stop(res chan<- *Response, mode ...SendMode) error {
+	return s.Roll(0, s.lastHeading(), RollStop, res, mode...)
+}
+
+func (s *Sphero) RollContext(ctx context.Context, speed uint8, heading uint16, state RollState, mode ...SendMode) error {
+	data, err := s.rollData(speed, heading, state)
+	if err != nil {
+		return err
+	}
+	_, err = s.Do(ctx, DID_SPHERO, CMD_ROLL, data, mode...)
+	return err
+}
+
+func (s *Sphero) StopContext(ctx context.Context, mode ...SendMode) error {
+	return s.RollContext(ctx, 0, s.lastHeading(), RollStop, mode...)
+}
+
+// Validates and encodes a Roll, remembering its heading for Stop.
+func (s *Sphero) rollData(speed uint8, heading uint16, state RollState) ([]byte, error) {
+	data, err := mustCommand(DID_SPHERO, CMD_ROLL).EncodeRequest(speed, heading, uint8(state))
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.heading = heading
+	s.mu.Unlock()
+	return data, nil
+}
+
+func (s *Sphero) lastHeading() uint16 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.heading
+}
+
+// MotorMode is what a motor does in raw motor mode, see SetRawMotorValues.
+type MotorMode uint8
+
+const (
+	MotorOff     MotorMode = 0x00 // Coast
+	MotorForward MotorMode = 0x01
+	MotorReverse MotorMode = 0x02
+	MotorBrake   MotorMode = 0x03
+	MotorIgnore  MotorMode = 0x04 // Leave the motor as it is
+)
+
+func (m MotorMode) String() string {
+	switch m {
+	case MotorOff:
+		return "off"
+	case MotorForward:
+		return "forward"
+	case MotorReverse:
+		return "reverse"
+	case MotorBrake:
+		return "brake"
+	case MotorIgnore:
+		return "ignore"
+	}
+	return fmt.Sprintf("MotorMode(%d)", uint8(m))
+}
+
+// SetRawMotorValues drives each motor directly with a mode and a PWM power,
+// 0 to 255. The Sphero turns stabilization off when it enters raw motor
+// mode, so Roll won't hold a heading until stabilization is turned back on,
+// see ResumeStabilizedDrivingContext. Stabilization stays off after
+// reconnecting.
+func (s *Sphero) SetRawMotorValues(leftMode MotorMode, leftPower uint8, rightMode MotorMode, rightPower uint8, res chan<- *Response, mode ...SendMode) error {
+	data, err := rawMotorData(leftMode, leftPower, rightMode, rightPower)
+	if err != nil {
+		return err
+	}
+	return s.Send(DID_SPHERO, CMD_SET_RAW_MOTORS, data, res, mode...)
+}
+
+func (s *Sphero) SetRawMotorValuesContext(ctx context.Context, leftMode MotorMode, leftPower uint8, rightMode MotorMode, rightPower uint8, mode ...SendMode) error {
+	data, err := rawMotorData(leftMode, leftPower, rightMode, rightPower)
+	if err != nil {
+		return err
+	}
+	_, err = s.Do(ctx, DID_SPHERO, CMD_SET_RAW_MOTORS, data, mode...)
+	return err
+}
+
+func rawMotorData(leftMode MotorMode, leftPower uint8, rightMode MotorMode, rightPower uint8) ([]byte, error) {
+	return mustCommand(DID_SPHERO, CMD_SET_RAW_MOTORS).EncodeRequest(uint8(leftMode), leftPower, uint8(rightMode), rightPower)
+}
+
+// ResumeStabilizedDrivingContext leaves raw motor mode: it turns both motors
+// off and stabilization back on, after which Roll holds headings again.
+func (s *Sphero) ResumeStabilizedDrivingContext(ctx context.Context, mode ...SendMode) error {
+	if err := s.SetRawMotorValuesContext(ctx, MotorOff, 0, MotorOff, 0, mode...); err != nil {
+		return err
+	}
+	return s.SetStabilizationContext(ctx, true, mode...)
+}
+
+// RawMotorMode reports whether the Sphero was last put in raw motor mode by
+// SetRawMotorValues and stabilization hasn't been turned back on since.
+func (s *Sphero) RawMotorMode() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rawMotors
+}
+
+// Follows the motor mode through the commands sent. Raw motor commands turn
+// stabilization off, which is recorded for reconnects as if SetStabilization
+// had been sent. Called with s.mu held.
+func (s *Sphero) trackMotors(did, cid uint8, data []byte) {
+	if did != DID_SPHERO {
+		return
+	}
+	switch cid {
+	case CMD_SET_RAW_MOTORS:
+		s.rawMotors = true
+		s.config[cmdKey(DID_SPHERO, CMD_SET_STABILIZ)] = stabilizationData(false)
+	case CMD_SET_STABILIZ:
+		if len(data) > 0 && data[0] != 0 {
+			s.rawMotors = false
+		}
+	}
+}
+
+// Rotation rates for SetRotationRate, in degrees per second. The Sphero
+// takes rates in units of RotationRateUnit up to 254 units, or its maximum
+// rate.
+const (
+	RotationRateUnit    = 0.784
+	MinRotationRate     = RotationRateUnit
+	DefaultRotationRate = 0xc8 * RotationRateUnit // About 157
+	MaxRotationRate     = 400
+)
+
+// SetRotationRate sets how fast the Sphero turns to meet a new heading, in
+// degrees per second. Slower turns are under better control but wider. The
+// rate is rounded to the nearest RotationRateUnit and must be between
+// MinRotationRate and 254 units, or MaxRotationRate.
+func (s *Sphero) SetRotationRate(degreesPerSecond float64, res chan<- *Response, mode ...SendMode) error {
+	data, err := rotationRateData(degreesPerSecond)
+	if err != nil {
+		return err
+	}
+	return s.Send(DID_SPHERO, CMD_SET_ROTATION_RATE, data, res, mode...)
+}
+
+func (s *Sphero) SetRotationRateContext(ctx context.Context, degreesPerSecond float64, mode ...SendMode) error {
+	data, err := rotationRateData(degreesPerSecond)
+	if err != nil {
+		return err
+	}
+	_, err = s.Do(ctx, DID_SPHERO, CMD_SET_ROTATION_RATE, data, mode...)
+	return err
+}
+
+func rotationRateData(degreesPerSecond float64) ([]byte, error) {
+	outOfRange := fmt.Errorf("%w: rotation rate is %g°/s, must be between %g°/s and %g°/s or %d°/s",
+		FieldRangeError, degreesPerSecond, MinRotationRate, 0xfe*RotationRateUnit, MaxRotationRate)
+	if math.IsNaN(degreesPerSecond) || math.IsInf(degreesPerSecond, 0) {
+		return nil, outOfRange
+	}
+
+	if degreesPerSecond == MaxRotationRate {
+		return mustCommand(DID_SPHERO, CMD_SET_ROTATION_RATE).EncodeRequest(uint8(0xff))
+	}
+	if degreesPerSecond < MinRotationRate || degreesPerSecond > 0xfe*RotationRateUnit {
+		return nil, outOfRange
+	}
+	rate := math.Round(degreesPerSecond / RotationRateUnit)
+	return mustCommand(DID_SPHERO, CMD_SET_ROTATION_RATE).EncodeRequest(uint8(rate))
+}
+
+// Boost is a burst of speed, see Sphero.Boost.
+const (
+	BoostUnit        = 10 * time.Millisecond
+	MaxBoostDuration = 0xff * BoostUnit
+)
+
+// Boost bursts forward at full speed towards `heading`, 0 to 359 degrees,
+// for `duration`. The duration is rounded to the nearest BoostUnit and must
+// be between BoostUnit and MaxBoostDuration.
+func (s *Sphero) Boost(duration time.Duration, heading uint16, res chan<- *Response, mode ...SendMode) error {
+	data, err := boostData(duration, heading)
+	if err != nil {
+		return err
+	}
+	return s.Send(DID_SPHERO, CMD_BOOST, data, res, mode...)
+}
+
+func (s *Sphero) BoostContext(ctx context.Context, duration time.Duration, heading uint16, mode ...SendMode) error {
+	data, err := boostData(duration, heading)
+	if err != nil {
+		return err
+	}
+	_, err = s.Do(ctx, DID_SPHERO, CMD_BOOST, data, mode...)
+	return err
+}
+
+func boostData(duration time.Duration, heading uint16) ([]byte, error) {
+	units := duration.Round(BoostUnit) / BoostUnit
+	if units < 1 || units > 0xff {
+		return nil, fmt.Errorf("%w: boost lasts %v, must be between %v and %v",
+			FieldRangeError, duration, BoostUnit, MaxBoostDuration)
+	}
+	return mustCommand(DID_SPHERO, CMD_BOOST).EncodeRequest(uint8(units), heading)
+}
@@ -0,0 +1,223 @@
+package sphero_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/FreeFlow/sphero"
+	"github.com/FreeFlow/sphero/spherosim"
+)
+
+// Returns a Sphero connected to a pipe and a function returning the `n` byte
+// frame `send` writes to it. Nothing answers.
+func pipe(t *testing.T) (*sphero.Sphero, func(n int, send func() error) []byte) {
+	client, device := net.Pipe()
+	s := sphero.NewSpheroConn(client, nil)
+	t.Cleanup(func() {
+		s.Close()
+		device.Close()
+	})
+
+	return s, func(n int, send func() error) []byte {
+		errc := make(chan error, 1)
+		go func() { errc <- send() }()
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(device, frame); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+		return frame
+	}
+}
+
+func TestRollFrames(t *testing.T) {
+	s, read := pipe(t)
+
+	// Half speed at 270 degrees, acknowledged as seq 0x01.
+	frame := read(11, func() error { return s.Roll(0x80, 270, sphero.RollNormal, nil) })
+	if want := []byte{0xff, 0xff, 0x02, 0x30, 0x01, 0x05, 0x80, 0x01, 0x0e, 0x01, 0x37}; !bytes.Equal(frame, want) {
+		t.Fatalf("Expected % x but got % x", want, frame)
+	}
+
+	for _, c := range []struct {
+		heading uint16
+		state   sphero.RollState
+	}{{360, sphero.RollNormal}, {0, 3}} {
+		if err := s.Roll(0x80, c.heading, c.state, nil); !errors.Is(err, sphero.FieldRangeError) {
+			t.Errorf("Roll(0x80, %d, %v): expected FieldRangeError but got %v", c.heading, c.state, err)
+		}
+	}
+
+	// Stop holds the heading of the last valid roll, fire and forget.
+	frame = read(11, func() error { return s.Stop(nil, sphero.ModeNoAnswer) })
+	if want := []byte{0xff, 0xfe, 0x02, 0x30, 0x00, 0x05, 0x00, 0x01, 0x0e, 0x00, 0xb9}; !bytes.Equal(frame, want) {
+		t.Fatalf("Expected % x but got % x", want, frame)
+	}
+}
+
+func TestRollContext(t *testing.T) {
+	sim, s, _ := connect(t)
+	ctx := timeout(t, time.Second)
+
+	if err := s.RollContext(ctx, 0x60, 90, sphero.RollNormal); err != nil {
+		t.Fatal(err)
+	}
+	if st := sim.State(); st.Speed != 0x60 || st.Heading != 90 || st.RollState != sphero.RollNormal {
+		t.Fatalf("Unexpected state after Roll %+v", st)
+	}
+
+	if err := s.StopContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if st := sim.State(); st.Speed != 0 || st.Heading != 90 || st.RollState != sphero.RollStop {
+		t.Fatalf("Unexpected state after Stop %+v", st)
+	}
+}
+
+func TestRawMotorMode(t *testing.T) {
+	sim, s, _ := connect(t)
+	ctx := timeout(t, time.Second)
+
+	events := make(chan sphero.ConnEvent, 16)
+	err := s.EnableReconnect(sphero.ReconnectOptions{
+		Dial:       func() (io.ReadWriteCloser, error) { return sim.Conn(), nil },
+		MinBackoff: time.Millisecond,
+		Events:     events,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SetStabilizationContext(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetRawMotorValuesContext(ctx, sphero.MotorForward, 0x80, sphero.MotorReverse, 0x80); err != nil {
+		t.Fatal(err)
+	}
+	if !s.RawMotorMode() {
+		t.Fatal("Expected raw motor mode")
+	}
+
+	// Reconnecting must not turn stabilization back on behind the raw
+	// motor commands.
+	sim.Reset()
+	waitEvent(t, events, sphero.ConnRestored)
+	if err := s.PingContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if sim.State().Stabilization {
+		t.Fatal("Expected stabilization to stay off after reconnecting")
+	}
+
+	if err := s.SetRawMotorValues(5, 0, sphero.MotorOff, 0, nil); !errors.Is(err, sphero.FieldRangeError) {
+		t.Fatalf("Expected FieldRangeError for an unknown motor mode but got %v", err)
+	}
+
+	if err := s.SetStabilizationContext(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	if s.RawMotorMode() {
+		t.Fatal("Expected stabilization to leave raw motor mode")
+	}
+}
+
+func TestResumeStabilizedDriving(t *testing.T) {
+	sim, s, _ := connect(t)
+	ctx := timeout(t, time.Second)
+
+	if err := s.SetRawMotorValuesContext(ctx, sphero.MotorForward, 0xc0, sphero.MotorIgnore, 0); err != nil {
+		t.Fatal(err)
+	}
+	st := sim.State()
+	if st.Stabilization || st.Motors != (spherosim.Motors{LeftMode: sphero.MotorForward, LeftPower: 0xc0}) {
+		t.Fatalf("Unexpected state in raw motor mode %+v", st)
+	}
+	if !s.RawMotorMode() {
+		t.Fatal("Expected raw motor mode")
+	}
+
+	if err := s.ResumeStabilizedDrivingContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	st = sim.State()
+	if !st.Stabilization || st.Motors != (spherosim.Motors{}) {
+		t.Fatalf("Unexpected state after resuming %+v", st)
+	}
+	if s.RawMotorMode() {
+		t.Fatal("Expected to have left raw motor mode")
+	}
+}
+
+func TestRotationRateFrames(t *testing.T) {
+	s, read := pipe(t)
+
+	cases := []struct {
+		dps  float64
+		rate byte
+	}{
+		{sphero.MinRotationRate, 0x01},
+		{157, 0xc8},
+		{sphero.DefaultRotationRate, 0xc8},
+		{199, 0xfe},
+		{sphero.MaxRotationRate, 0xff},
+	}
+	for _, c := range cases {
+		frame := read(8, func() error { return s.SetRotationRate(c.dps, nil, sphero.ModeNoAnswer) })
+		if frame[6] != c.rate {
+			t.Errorf("%g°/s: expected %#02x but got % x", c.dps, c.rate, frame)
+		}
+	}
+	for _, dps := range []float64{0, 0.3, 0.4, 0.78, -10, 199.5, 200, 300, 401, math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if err := s.SetRotationRate(dps, nil); !errors.Is(err, sphero.FieldRangeError) {
+			t.Errorf("%g°/s: expected FieldRangeError but got %v", dps, err)
+		}
+	}
+}
+
+func TestBoostFrames(t *testing.T) {
+	s, read := pipe(t)
+
+	frame := read(10, func() error { return s.Boost(time.Second, 45, nil, sphero.ModeNoAnswer) })
+	if want := []byte{0x64, 0x00, 0x2d}; !bytes.Equal(frame[6:9], want) {
+		t.Fatalf("Expected % x but got % x", want, frame)
+	}
+	frame = read(10, func() error { return s.Boost(sphero.MaxBoostDuration, 359, nil, sphero.ModeNoAnswer) })
+	if want := []byte{0xff, 0x01, 0x67}; !bytes.Equal(frame[6:9], want) {
+		t.Fatalf("Expected % x but got % x", want, frame)
+	}
+
+	for _, c := range []struct {
+		d       time.Duration
+		heading uint16
+	}{{0, 0}, {4 * time.Millisecond, 0}, {3 * time.Second, 0}, {time.Second, 360}} {
+		if err := s.Boost(c.d, c.heading, nil); !errors.Is(err, sphero.FieldRangeError) {
+			t.Errorf("%v at %d: expected FieldRangeError but got %v", c.d, c.heading, err)
+		}
+	}
+}
+
+func TestRotationRateAndBoost(t *testing.T) {
+	sim, s, _ := connect(t)
+	ctx := timeout(t, time.Second)
+
+	if err := s.SetRotationRateContext(ctx, sphero.MaxRotationRate); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.BoostContext(ctx, 500*time.Millisecond, 180); err != nil {
+		t.Fatal(err)
+	}
+	st := sim.State()
+	if st.RotationRate != 0xff {
+		t.Fatalf("Expected the maximum rotation rate but got %#02x", st.RotationRate)
+	}
+	if st.Boost != (spherosim.Boost{Duration: 500 * time.Millisecond, Heading: 180}) {
+		t.Fatalf("Unexpected boost %+v", st.Boost)
+	}
+}
@@ -21,4 +21,19 @@ var (
 	ApplicationCorruptError   = errors.New("Main application corrupt")
 	MessageTimeoutError       = errors.New("Message state machine timed out")
 	UnknownError              = errors.New("Unkown error")
+	DeadlineUnsupportedError  = errors.New("Transport does not support deadlines")
+	ConnectionLostError       = errors.New("Connection lost")
+	ReplayMismatchError       = errors.New("Write does not match the recording")
+	DisconnectedError         = errors.New("Disconnected from the Sphero")
+	SequenceExhaustedError    = errors.New("Every sequence number is waiting for an answer")
+	ResponseTimeoutError      = errors.New("Timed out waiting for a response")
+	PayloadLayoutError        = errors.New("Payload doesn't match the command layout")
+	FieldRangeError           = errors.New("Field value out of range")
+	ResponseMismatchError     = errors.New("Response answers a different command")
+	UnboundResponseError      = errors.New("Response isn't bound to a known command")
+	CalibrationDoneError      = errors.New("Calibration already committed or cancelled")
+	AnswerRequiredError       = errors.New("Command needs an answer but the send mode requests none")
+	InvalidSendModeError      = errors.New("Send mode isn't a command SOP2")
+	ClosedError               = errors.New("Connection closed")
+	NoDialerError             = errors.New("No way to reopen the transport, set ReconnectOptions.Dial")
 )
@@ -0,0 +1,93 @@
+package sphero
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// Logger receives log records from a Sphero. It matches the Log method of
+// *slog.Logger so one can be used directly.
+type Logger interface {
+	Log(ctx context.Context, level slog.Level, msg string, args ...any)
+}
+
+// SetLogger sets where the Sphero logs recovered errors, connection
+// lifecycle events and, with tracing on, protocol frames. Without a logger
+// nothing is logged.
+func (s *Sphero) SetLogger(l Logger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logger = l
+}
+
+// SetTrace turns protocol tracing on or off. While on, every command sent
+// and every answer and async packet received is logged at slog.LevelDebug
+// with the names from const.go.
+func (s *Sphero) SetTrace(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trace = on
+}
+
+func (s *Sphero) log(level slog.Level, msg string, args ...any) {
+	s.mu.Lock()
+	l := s.logger
+	s.mu.Unlock()
+
+	if l != nil {
+		l.Log(context.Background(), level, msg, args...)
+	}
+}
+
+func (s *Sphero) tracing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.trace && s.logger != nil
+}
+
+func (s *Sphero) traceCommand(sop2, did, cid, seq uint8, data []byte) {
+	if !s.tracing() {
+		return
+	}
+	args := []any{
+		"sop2", fmt.Sprintf("%#02x", sop2),
+		"did", DeviceName(did),
+		"cid", CommandName(did, cid),
+		"seq", seq,
+		"data", fmt.Sprintf("%x", data),
+	}
+	if c, ok := LookupCommand(did, cid); ok && len(data) > 0 {
+		if vs, err := c.DecodeRequest(data); err == nil {
+			args = append(args, "fields", vs.String())
+		}
+	}
+	s.log(slog.LevelDebug, "sphero: send", args...)
+}
+
+func (s *Sphero) traceAnswer(r *Response) {
+	if !s.tracing() {
+		return
+	}
+	s.log(slog.LevelDebug, "sphero: answer",
+		"seq", r.Seq,
+		"mrsp", ResponseCodeName(r.Mrsp),
+		"data", fmt.Sprintf("%x", r.Data))
+}
+
+func (s *Sphero) traceAsync(r *AsyncResponse) {
+	if !s.tracing() {
+		return
+	}
+	args := []any{
+		"id", AsyncIdName(r.IdCode),
+		"dlen", r.Dlen,
+		"data", fmt.Sprintf("%x", r.Data),
+	}
+	if a, ok := LookupAsync(r.IdCode); ok && len(r.Data) > 0 {
+		if vs, err := a.Decode(r.Data); err == nil {
+			args = append(args, "fields", vs.String())
+		}
+	}
+	s.log(slog.LevelDebug, "sphero: async", args...)
+}
@@ -0,0 +1,53 @@
+package sphero_test
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/FreeFlow/sphero"
+	"github.com/FreeFlow/sphero/spherosim"
+)
+
+func TestTrace(t *testing.T) {
+	sim := spherosim.New()
+	defer sim.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	s := sphero.NewSpheroConn(sim.Conn(), nil)
+	s.SetLogger(logger)
+	s.SetTrace(true)
+
+	ch := make(chan *sphero.Response, 1)
+	s.Ping(ch)
+	<-ch
+	s.Close()
+
+	out := buf.String()
+	for _, want := range []string{
+		`msg="sphero: send"`, "did=DID_CORE", "cid=CMD_PING",
+		`msg="sphero: answer"`, "mrsp=ORBOTIX_RSP_CODE_OK",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected trace to contain %s:\n%s", want, out)
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	cases := []struct{ got, want string }{
+		{sphero.DeviceName(sphero.DID_SPHERO), "DID_SPHERO"},
+		{sphero.CommandName(sphero.DID_SPHERO, sphero.CMD_ROLL), "CMD_ROLL"},
+		{sphero.CommandName(sphero.DID_CORE, 0x7f), "CMD(0x7f)"},
+		{sphero.ResponseCodeName(sphero.ORBOTIX_RSP_CODE_EPARAM), "ORBOTIX_RSP_CODE_EPARAM"},
+		{sphero.AsyncIdName(sphero.ID_COLLISION_DETECTED), "ID_COLLISION_DETECTED"},
+	}
+	for _, c := range cases {
+		if c.got != c.want {
+			t.Errorf("Expected %s but got %s", c.want, c.got)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package sphero
+
+import (
+	"fmt"
+)
+
+// SendMode selects the SOP2 a command is sent with, which tells the Sphero
+// whether to answer it and whether it resets the client inactivity timeout.
+// Every command method takes an optional SendMode, ModeAnswer by default,
+// and fails with InvalidSendModeError given any other value than the modes
+// below.
+//
+// Commands sent without an answer cost no acknowledgement and no response
+// slot, which suits high rate commands such as Roll or LED animation. Their
+// response channel is ignored and the synchronous variants return as soon as
+// the command is written.
+type SendMode uint8
+
+const (
+	ModeAnswer          SendMode = 0xfc | SOP2_FLAG_ANSWER | SOP2_FLAG_RESET_TIMEOUT // 0xff, the default
+	ModeNoAnswer        SendMode = 0xfc | SOP2_FLAG_RESET_TIMEOUT                    // 0xfe
+	ModeAnswerNoReset   SendMode = 0xfc | SOP2_FLAG_ANSWER                           // 0xfd, leaves the inactivity timeout running
+	ModeNoAnswerNoReset SendMode = 0xfc                                              // 0xfc
+)
+
+// Reports whether the mode is a command SOP2, 0xfc to 0xff.
+func (m SendMode) valid() bool {
+	return m&0xfc == 0xfc
+}
+
+// Answer reports whether the Sphero answers commands sent in this mode.
+func (m SendMode) Answer() bool {
+	return m&SOP2_FLAG_ANSWER != 0
+}
+
+// ResetTimeout reports whether commands sent in this mode reset the client
+// inactivity timeout.
+func (m SendMode) ResetTimeout() bool {
+	return m&SOP2_FLAG_RESET_TIMEOUT != 0
+}
+
+func (m SendMode) String() string {
+	switch m {
+	case ModeAnswer:
+		return "answer"
+	case ModeNoAnswer:
+		return "no answer"
+	case ModeAnswerNoReset:
+		return "answer, no timeout reset"
+	case ModeNoAnswerNoReset:
+		return "no answer, no timeout reset"
+	}
+	return fmt.Sprintf("SendMode(%#02x)", uint8(m))
+}
+
+// Returns the mode passed to a command method, if any.
+func sendMode(mode []SendMode) SendMode {
+	if len(mode) > 0 {
+		return mode[0]
+	}
+	return ModeAnswer
+}
@@ -0,0 +1,67 @@
+package sphero
+
+import (
+	"fmt"
+)
+
+// Names of the constants in const.go, used for logging and tracing. Command
+// and async names come from the registry in commands.go.
+
+var deviceNames = map[uint8]string{
+	DID_CORE:       "DID_CORE",
+	DID_BOOTLOADER: "DID_BOOTLOADER",
+	DID_SPHERO:     "DID_SPHERO",
+}
+
+var responseCodeNames = map[uint8]string{
+	ORBOTIX_RSP_CODE_OK:           "ORBOTIX_RSP_CODE_OK",
+	ORBOTIX_RSP_CODE_EGEN:         "ORBOTIX_RSP_CODE_EGEN",
+	ORBOTIX_RSP_CODE_ECHKSUM:      "ORBOTIX_RSP_CODE_ECHKSUM",
+	ORBOTIX_RSP_CODE_EFRAG:        "ORBOTIX_RSP_CODE_EFRAG",
+	ORBOTIX_RSP_CODE_EBAD_CMD:     "ORBOTIX_RSP_CODE_EBAD_CMD",
+	ORBOTIX_RSP_CODE_EUNSUPP:      "ORBOTIX_RSP_CODE_EUNSUPP",
+	ORBOTIX_RSP_CODE_EBAD_MSG:     "ORBOTIX_RSP_CODE_EBAD_MSG",
+	ORBOTIX_RSP_CODE_EPARAM:       "ORBOTIX_RSP_CODE_EPARAM",
+	ORBOTIX_RSP_CODE_EEXEC:        "ORBOTIX_RSP_CODE_EEXEC",
+	ORBOTIX_RSP_CODE_EBAD_DID:     "ORBOTIX_RSP_CODE_EBAD_DID",
+	ORBOTIX_RSP_CODE_POWER_NOGOOD: "ORBOTIX_RSP_CODE_POWER_NOGOOD",
+	ORBOTIX_RSP_CODE_PAGE_ILLEGAL: "ORBOTIX_RSP_CODE_PAGE_ILLEGAL",
+	ORBOTIX_RSP_CODE_FLASH_FAIL:   "ORBOTIX_RSP_CODE_FLASH_FAIL",
+	ORBOTIX_RSP_CODE_MA_CORRUPT:   "ORBOTIX_RSP_CODE_MA_CORRUPT",
+	ORBOTIX_RSP_CODE_MSG_TIMEOUT:  "ORBOTIX_RSP_CODE_MSG_TIMEOUT",
+}
+
+// DeviceName returns the name of a device ID, e.g. "DID_CORE".
+func DeviceName(did uint8) string {
+	if name, ok := deviceNames[did]; ok {
+		return name
+	}
+	return fmt.Sprintf("DID(%#02x)", did)
+}
+
+// CommandName returns the name of a command ID for the device, e.g.
+// "CMD_PING".
+func CommandName(did, cid uint8) string {
+	if c, ok := LookupCommand(did, cid); ok {
+		return c.Name
+	}
+	return fmt.Sprintf("CMD(%#02x)", cid)
+}
+
+// ResponseCodeName returns the name of a message response code, e.g.
+// "ORBOTIX_RSP_CODE_OK".
+func ResponseCodeName(mrsp uint8) string {
+	if name, ok := responseCodeNames[mrsp]; ok {
+		return name
+	}
+	return fmt.Sprintf("MRSP(%#02x)", mrsp)
+}
+
+// AsyncIdName returns the name of an async message ID code, e.g.
+// "ID_COLLISION_DETECTED".
+func AsyncIdName(id uint8) string {
+	if a, ok := LookupAsync(id); ok {
+		return a.Name
+	}
+	return fmt.Sprintf("ID(%#02x)", id)
+}
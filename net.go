@@ -0,0 +1,37 @@
+package sphero
+
+import (
+	"io"
+	"net"
+)
+
+// netTransport is a network connection to a Sphero, usually one exposed by
+// sphero-bridge.
+type netTransport struct {
+	net.Conn
+	name string
+}
+
+func (t *netTransport) Name() string {
+	return t.name
+}
+
+// Dial connects to a Sphero exposed over the network, e.g. by
+// `sphero-bridge`, see net.Dial for valid networks and addresses. The
+// returned Sphero works exactly like a local one, including async
+// responses.
+func Dial(network, address string, async chan<- *AsyncResponse) (*Sphero, error) {
+	dial := func() (io.ReadWriteCloser, error) {
+		conn, err := net.Dial(network, address)
+		if err != nil {
+			return nil, err
+		}
+		return &netTransport{conn, network + "://" + address}, nil
+	}
+
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	return newSphero(conn, async, dial), nil
+}
@@ -0,0 +1,137 @@
+package sphero
+
+import (
+	"time"
+)
+
+// DefaultResponseTimeout is how long a command waits for its answer unless
+// changed with SetResponseTimeout.
+const DefaultResponseTimeout = 10 * time.Second
+
+// How long responses still undelivered when the connection ends wait for
+// their channel before they're dropped.
+const deliveryGrace = time.Second
+
+// pending is a single-use response slot for a command waiting for its
+// answer. Slots are removed when the answer arrives, when they time out and
+// when the connection ends or drops, whichever happens first.
+type pending struct {
+	ch       chan<- *Response // May be nil if the caller doesn't want the answer
+	timer    *time.Timer
+	did, cid uint8
+	sent     time.Time
+}
+
+// SetResponseTimeout sets how long commands sent from now on wait for their
+// answer. When it passes the response channel receives a Response whose
+// Error is ResponseTimeoutError and a late answer is dropped. Zero or less
+// waits forever.
+func (s *Sphero) SetResponseTimeout(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timeout = d
+}
+
+// Creates the slot for `seq`. Called with s.mu held.
+func (s *Sphero) newPending(seq, did, cid uint8, res chan<- *Response) *pending {
+	p := &pending{ch: res, did: did, cid: cid, sent: time.Now()}
+	if s.timeout > 0 {
+		p.timer = time.AfterFunc(s.timeout, func() {
+			s.expire(seq, p)
+		})
+	}
+	return p
+}
+
+// Stops the slot's timeout, if any.
+func (p *pending) stop() {
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+}
+
+// Fails the slot for `seq` with ResponseTimeoutError if it's still waiting.
+func (s *Sphero) expire(seq uint8, p *pending) {
+	s.mu.Lock()
+	if s.res[seq] != p {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.res, seq)
+	s.mu.Unlock()
+
+	s.stats.update(func(st *Stats) { st.Timeouts++ })
+	s.deliver(p.ch, p.failure(seq, ResponseTimeoutError))
+}
+
+// Removes the response slot for `seq` if it still belongs to `res`.
+func (s *Sphero) release(seq uint8, res chan<- *Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, ok := s.res[seq]; ok && p.ch == res {
+		p.stop()
+		delete(s.res, seq)
+	}
+}
+
+// Records the command the slot was waiting on in its response.
+func (p *pending) bind(r *Response) {
+	r.Did, r.Cid, r.Sent = p.did, p.cid, p.sent
+	r.cmd, _ = LookupCommand(p.did, p.cid)
+}
+
+// Returns the Response delivered in place of an answer when the slot fails
+// with `err`.
+func (p *pending) failure(seq uint8, err error) *Response {
+	r := &Response{Sop1: SOP1, Sop2: SOP2_ANSWER, Seq: seq, err: err}
+	p.bind(r)
+	return r
+}
+
+// Delivers `r` on `ch`, waiting until the channel takes it or the connection
+// ends. After that it waits at most until the delivery grace period is over,
+// so callers can still pick up the error the connection ended with.
+func (s *Sphero) deliver(ch chan<- *Response, r *Response) {
+	if ch == nil {
+		return
+	}
+	select {
+	case ch <- r:
+		return
+	case <-s.done:
+	}
+	select {
+	case ch <- r:
+	case <-s.expired:
+	}
+}
+
+// Fails every pending request with `err`. Channels that can take their
+// Response right away get it now, the rest are waited on in turn from a
+// single goroutine so the caller isn't held up.
+func (s *Sphero) failPending(inFlight map[uint8]*pending, err error) {
+	var waiting []*pending
+	var failed []*Response
+	for seq, p := range inFlight {
+		p.stop()
+		if p.ch == nil {
+			continue
+		}
+		r := p.failure(seq, err)
+		select {
+		case p.ch <- r:
+		default:
+			waiting = append(waiting, p)
+			failed = append(failed, r)
+		}
+	}
+	if len(waiting) == 0 {
+		return
+	}
+	go func() {
+		for i, p := range waiting {
+			s.deliver(p.ch, failed[i])
+		}
+	}()
+}
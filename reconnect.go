@@ -0,0 +1,256 @@
+package sphero
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// ConnState is a connection lifecycle state reported while reconnecting.
+type ConnState int
+
+const (
+	ConnDisconnected ConnState = iota // The link dropped, in-flight requests failed
+	ConnReconnecting                  // Attempting to reopen the transport
+	ConnRestored                      // Reconnected and configuration re-applied
+	ConnFailed                        // Gave up reconnecting
+)
+
+func (c ConnState) String() string {
+	switch c {
+	case ConnDisconnected:
+		return "disconnected"
+	case ConnReconnecting:
+		return "reconnecting"
+	case ConnRestored:
+		return "restored"
+	case ConnFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("ConnState(%d)", int(c))
+}
+
+// ConnEvent reports a change in connection state.
+type ConnEvent struct {
+	State   ConnState
+	Attempt int   // Reconnect attempt, starting at 1
+	Err     error // Why the link dropped or the attempt failed, if known
+}
+
+// ReconnectOptions configure automatic reconnection. See EnableReconnect.
+type ReconnectOptions struct {
+	// Dial reopens the transport. Defaults to reopening the serial device or
+	// network address the Sphero was created with.
+	Dial func() (io.ReadWriteCloser, error)
+
+	// Delay before the first attempt, doubled after every failed attempt up
+	// to MaxBackoff. Default to 100ms and 5s.
+	MinBackoff, MaxBackoff time.Duration
+
+	// Attempts before giving up, 0 retries forever. An attempt fails if the
+	// transport can't be reopened or any restored command isn't answered
+	// with ORBOTIX_RSP_CODE_OK.
+	MaxAttempts int
+
+	// How long each restored command waits for its answer. Defaults to 2s.
+	RestoreTimeout time.Duration
+
+	// Events receives lifecycle events. Events are dropped rather than
+	// blocking the connection, so use a buffered channel.
+	Events chan<- ConnEvent
+}
+
+// The commands re-applied after reconnecting, in order.
+var restoreOrder = []uint16{
+	cmdKey(DID_SPHERO, CMD_SET_STABILIZ),
+	cmdKey(DID_SPHERO, CMD_SET_RGB_LED),
+	cmdKey(DID_SPHERO, CMD_SET_BACK_LED),
+	cmdKey(DID_SPHERO, CMD_SET_COLLISION_DET),
+	cmdKey(DID_CORE, CMD_SET_PWR_NOTIFY),
+	cmdKey(DID_SPHERO, CMD_SET_DATA_STREAMING),
+}
+
+func cmdKey(did, cid uint8) uint16 {
+	return uint16(did)<<8 | uint16(cid)
+}
+
+// Reports whether a command changes configuration that's re-applied after
+// reconnecting.
+func restorable(did, cid uint8) bool {
+	key := cmdKey(did, cid)
+	for _, k := range restoreOrder {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// EnableReconnect turns on automatic reconnection. When reading from the
+// transport fails the Sphero reopens it with backoff, then re-applies the
+// last stabilization, LED, collision detection, power notification and data
+// streaming settings that were sent. The connection only counts as restored
+// once the Sphero answered each of them successfully. Requests in flight
+// when the link drops receive a Response whose Error is DisconnectedError.
+func (s *Sphero) EnableReconnect(opts ReconnectOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if opts.Dial == nil {
+		if s.redial == nil {
+			return NoDialerError
+		}
+		opts.Dial = s.redial
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = 100 * time.Millisecond
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = 5 * time.Second
+		if opts.MaxBackoff < opts.MinBackoff {
+			opts.MaxBackoff = opts.MinBackoff
+		}
+	}
+	if opts.RestoreTimeout <= 0 {
+		opts.RestoreTimeout = 2 * time.Second
+	}
+	s.reconnect = &opts
+	return nil
+}
+
+func (s *Sphero) emit(e ConnEvent) {
+	s.mu.Lock()
+	events := s.reconnect.Events
+	s.mu.Unlock()
+
+	level := slog.LevelInfo
+	switch e.State {
+	case ConnDisconnected:
+		level = slog.LevelWarn
+	case ConnFailed:
+		level = slog.LevelError
+	}
+	s.log(level, "sphero: "+e.State.String(), "attempt", e.Attempt, "err", e.Err)
+
+	select {
+	case events <- e:
+	default:
+	}
+}
+
+// Reopens the transport after a read failure, or after restoring the
+// configuration over the last one failed. Reports whether a new transport is
+// in place, false means the Sphero was closed or reconnecting gave up. The
+// configuration is restored from another goroutine as the answers arrive
+// through the listener, see restore.
+func (s *Sphero) reconnectLoop(cause error) bool {
+	s.mu.Lock()
+	opts := *s.reconnect
+	old := s.conn
+	inFlight := s.res
+	s.res = make(map[uint8]*pending)
+	s.disconnected = true
+	s.restoreErr = nil
+	failed := s.attempt // Attempts since the link dropped
+	s.mu.Unlock()
+
+	old.Close()
+	if failed == 0 {
+		s.emit(ConnEvent{State: ConnDisconnected, Err: cause})
+	}
+	s.failPending(inFlight, DisconnectedError)
+
+	backoff := opts.MinBackoff
+	for i := 0; i < failed && backoff < opts.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > opts.MaxBackoff {
+		backoff = opts.MaxBackoff
+	}
+
+	err := cause
+	for attempt := failed + 1; opts.MaxAttempts == 0 || attempt <= opts.MaxAttempts; attempt++ {
+		select {
+		case <-s.done:
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > opts.MaxBackoff {
+			backoff = opts.MaxBackoff
+		}
+
+		s.emit(ConnEvent{State: ConnReconnecting, Attempt: attempt})
+
+		var conn io.ReadWriteCloser
+		if conn, err = opts.Dial(); err != nil {
+			continue
+		}
+
+		s.mu.Lock()
+		if s.err != nil {
+			s.mu.Unlock()
+			conn.Close()
+			return false
+		}
+		s.conn = conn
+		s.disconnected = false
+		s.attempt = attempt
+		s.mu.Unlock()
+
+		go s.restore(conn, attempt, opts.RestoreTimeout)
+		return true
+	}
+
+	s.emit(ConnEvent{State: ConnFailed, Err: err})
+	return false
+}
+
+// Re-sends the remembered configuration commands over `conn` and waits for
+// their answers. Emits ConnRestored if they all succeed, otherwise closes
+// `conn` so the listener counts the attempt as failed and reconnects.
+func (s *Sphero) restore(conn io.ReadWriteCloser, attempt int, timeout time.Duration) {
+	err := s.reapply(timeout)
+
+	s.mu.Lock()
+	if s.conn != conn || s.disconnected || s.err != nil {
+		// The link dropped or the Sphero closed meanwhile, the listener
+		// already took over.
+		s.mu.Unlock()
+		return
+	}
+	if err != nil {
+		s.restoreErr = err
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.attempt = 0
+	s.mu.Unlock()
+
+	s.emit(ConnEvent{State: ConnRestored, Attempt: attempt})
+}
+
+// Re-sends the remembered configuration commands in order, each waiting up
+// to `timeout` for a successful answer.
+func (s *Sphero) reapply(timeout time.Duration) error {
+	s.mu.Lock()
+	config := make(map[uint16][]byte, len(s.config))
+	for k, v := range s.config {
+		config[k] = v
+	}
+	s.mu.Unlock()
+
+	for _, key := range restoreOrder {
+		if data, ok := config[key]; ok {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			_, err := s.Do(ctx, uint8(key>>8), uint8(key), data)
+			cancel()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package sphero_test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/FreeFlow/sphero"
+	"github.com/FreeFlow/sphero/codec"
+	"github.com/FreeFlow/sphero/spherosim"
+)
+
+func waitEvent(t *testing.T, events <-chan sphero.ConnEvent, state sphero.ConnState) sphero.ConnEvent {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.State == state {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for %v", state)
+		}
+	}
+}
+
+func TestReconnectRestoresState(t *testing.T) {
+	sim := spherosim.New()
+	defer sim.Close()
+
+	s := sphero.NewSpheroConn(sim.Conn(), nil)
+	defer s.Close()
+
+	events := make(chan sphero.ConnEvent, 16)
+	err := s.EnableReconnect(sphero.ReconnectOptions{
+		Dial:       func() (io.ReadWriteCloser, error) { return sim.Conn(), nil },
+		MinBackoff: time.Millisecond,
+		Events:     events,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan *sphero.Response, 1)
+	s.SetRGBLEDOutput(0xff, 0x80, 0x00, true, ch)
+	<-ch
+	s.SetStabilization(false, ch)
+	<-ch
+	s.ConfigureCollisionDetection(0x01, 40, 40, 50, 50, 10, ch)
+	<-ch
+
+	sim.Reset() // Reboot, dropping the link and forgetting everything
+
+	if e := waitEvent(t, events, sphero.ConnDisconnected); e.Err == nil {
+		t.Fatal("Expected the disconnect cause")
+	}
+	waitEvent(t, events, sphero.ConnRestored)
+
+	st := sim.State()
+	if st.UserColor != (sphero.Color{R: 0xff, G: 0x80}) || st.Stabilization || st.Collision.Method != 0x01 {
+		t.Fatalf("State not restored: %#v", st)
+	}
+}
+
+func TestReconnectFailsInFlight(t *testing.T) {
+	client, device := net.Pipe()
+	defer device.Close()
+
+	s := sphero.NewSpheroConn(client, nil)
+	defer s.Close()
+
+	events := make(chan sphero.ConnEvent, 16)
+	redials := make(chan net.Conn, 1)
+	s.EnableReconnect(sphero.ReconnectOptions{
+		Dial: func() (io.ReadWriteCloser, error) {
+			client, device := net.Pipe()
+			redials <- device
+			return client, nil
+		},
+		MinBackoff: time.Millisecond,
+		Events:     events,
+	})
+
+	// The device reads the ping but never answers.
+	ch := make(chan *sphero.Response, 1)
+	go io.Copy(io.Discard, device)
+	if err := s.Ping(ch); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Close() // Drop the link under the listener
+
+	select {
+	case r := <-ch:
+		if r.Error() != sphero.DisconnectedError {
+			t.Fatalf("Expected DisconnectedError but got %v", r.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("In-flight request not failed")
+	}
+
+	waitEvent(t, events, sphero.ConnRestored)
+	(<-redials).Close()
+}
+
+func TestReconnectGivesUp(t *testing.T) {
+	sim := spherosim.New()
+	defer sim.Close()
+
+	s := sphero.NewSpheroConn(sim.Conn(), nil)
+	defer s.Close()
+
+	events := make(chan sphero.ConnEvent, 16)
+	s.EnableReconnect(sphero.ReconnectOptions{
+		Dial:        func() (io.ReadWriteCloser, error) { return nil, io.ErrUnexpectedEOF },
+		MinBackoff:  time.Millisecond,
+		MaxAttempts: 3,
+		Events:      events,
+	})
+
+	sim.Drop()
+	if e := waitEvent(t, events, sphero.ConnFailed); e.Err != io.ErrUnexpectedEOF {
+		t.Fatalf("Expected the last dial error but got %v", e.Err)
+	}
+
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Connection should have ended")
+	}
+	if err := s.Ping(nil); err == nil || err != s.Err() {
+		t.Fatalf("Expected %v but got %v", s.Err(), err)
+	}
+}
+
+func TestEnableReconnectNeedsDialer(t *testing.T) {
+	sim := spherosim.New()
+	defer sim.Close()
+
+	s := sphero.NewSpheroConn(sim.Conn(), nil)
+	defer s.Close()
+
+	if err := s.EnableReconnect(sphero.ReconnectOptions{}); err != sphero.NoDialerError {
+		t.Fatalf("Expected NoDialerError but got %v", err)
+	}
+}
+
+// Answers every command read from `conn` with `mrsp`.
+func answerAll(conn net.Conn, mrsp uint8) {
+	dec := codec.NewCommandDecoder(conn)
+	enc := codec.NewEncoder(conn)
+	for {
+		p, err := dec.Decode()
+		if err != nil {
+			return
+		}
+		cmd := p.(*codec.CommandPacket)
+		enc.Encode(&codec.AnswerPacket{Mrsp: mrsp, Seq: cmd.Seq})
+	}
+}
+
+func TestReconnectChecksRestore(t *testing.T) {
+	sim := spherosim.New()
+	defer sim.Close()
+
+	s := sphero.NewSpheroConn(sim.Conn(), nil)
+	defer s.Close()
+
+	// The first link rejects the restored commands, the second never answers
+	// them and the third is the Sphero.
+	var attempt int
+	events := make(chan sphero.ConnEvent, 16)
+	s.EnableReconnect(sphero.ReconnectOptions{
+		Dial: func() (io.ReadWriteCloser, error) {
+			attempt++
+			if attempt == 3 {
+				return sim.Conn(), nil
+			}
+			client, device := net.Pipe()
+			t.Cleanup(func() { device.Close() })
+			if attempt == 1 {
+				go answerAll(device, sphero.ORBOTIX_RSP_CODE_EPARAM)
+			} else {
+				go io.Copy(io.Discard, device)
+			}
+			return client, nil
+		},
+		MinBackoff:     time.Millisecond,
+		RestoreTimeout: 50 * time.Millisecond,
+		Events:         events,
+	})
+
+	ch := make(chan *sphero.Response, 1)
+	s.SetRGBLEDOutput(0xff, 0x80, 0x00, true, ch)
+	<-ch
+
+	sim.Drop()
+
+	var restored sphero.ConnEvent
+	timeout := time.After(time.Second)
+	for restored.State != sphero.ConnRestored {
+		select {
+		case e := <-events:
+			switch e.State {
+			case sphero.ConnRestored:
+				restored = e
+			case sphero.ConnFailed:
+				t.Fatalf("Unexpected %v", e.State)
+			}
+		case <-timeout:
+			t.Fatal("Timed out waiting for restored")
+		}
+	}
+	if restored.Attempt != 3 {
+		t.Fatalf("Expected to be restored on attempt 3 but got %d", restored.Attempt)
+	}
+	if st := sim.State(); st.UserColor != (sphero.Color{R: 0xff, G: 0x80}) {
+		t.Fatalf("State not restored: %#v", st)
+	}
+}
+
+func TestReconnectGivesUpOnRestore(t *testing.T) {
+	sim := spherosim.New()
+	defer sim.Close()
+
+	s := sphero.NewSpheroConn(sim.Conn(), nil)
+	defer s.Close()
+
+	events := make(chan sphero.ConnEvent, 16)
+	s.EnableReconnect(sphero.ReconnectOptions{
+		Dial: func() (io.ReadWriteCloser, error) {
+			client, device := net.Pipe()
+			t.Cleanup(func() { device.Close() })
+			go answerAll(device, sphero.ORBOTIX_RSP_CODE_EPARAM)
+			return client, nil
+		},
+		MinBackoff:  time.Millisecond,
+		MaxAttempts: 2,
+		Events:      events,
+	})
+
+	ch := make(chan *sphero.Response, 1)
+	s.SetStabilization(false, ch)
+	<-ch
+
+	sim.Drop()
+	if e := waitEvent(t, events, sphero.ConnFailed); e.Err != sphero.InvalidParametersError {
+		t.Fatalf("Expected InvalidParametersError but got %v", e.Err)
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Connection should have ended")
+	}
+}
@@ -0,0 +1,372 @@
+package sphero
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+/*
+	Session recordings capture every byte read from and written to a
+	transport. All integers are big-endian, like the Sphero protocol itself.
+
+	A recording starts with a header:
+
+		magic    [4]byte  "SPRC"
+		version  uint8    currently 1
+		start    int64    wall clock time of the recording start, unix nanoseconds
+
+	followed by one record per Read or Write:
+
+		dir      uint8    0 = read from the Sphero, 1 = written to the Sphero
+		offset   int64    nanoseconds since start
+		length   uint32   number of data bytes
+		data     [length]byte
+*/
+
+const (
+	recordingMagic   = "SPRC"
+	recordingVersion = 1
+
+	// Longest record read back. A single Read or Write is far shorter; longer
+	// records come from corrupt recordings.
+	maxRecordLength = 1 << 20
+)
+
+// Direction of a recorded chunk of bytes.
+type Direction uint8
+
+const (
+	DirRead  Direction = 0 // Read from the Sphero
+	DirWrite Direction = 1 // Written to the Sphero
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirRead:
+		return "read"
+	case DirWrite:
+		return "write"
+	}
+	return fmt.Sprintf("Direction(%d)", uint8(d))
+}
+
+// Record is a single chunk of bytes read or written.
+type Record struct {
+	Dir    Direction
+	Offset time.Duration // Since the start of the recording
+	Data   []byte
+}
+
+type recordHeader struct {
+	Dir    Direction
+	Offset int64
+	Length uint32
+}
+
+// Recorder wraps a transport and records every byte read from or written to
+// it. Pass it to NewSpheroConn in place of the transport it wraps.
+type Recorder struct {
+	conn  io.ReadWriteCloser
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+	err   error
+}
+
+// NewRecorder writes the recording header to `w` and returns a transport
+// which records all traffic over `conn` to `w`.
+func NewRecorder(conn io.ReadWriteCloser, w io.Writer) (*Recorder, error) {
+	r := &Recorder{
+		conn:  conn,
+		w:     w,
+		start: time.Now(),
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(recordingMagic)
+	buf.WriteByte(recordingVersion)
+	binary.Write(&buf, binary.BigEndian, r.start.UnixNano())
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Recorder) record(dir Direction, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, recordHeader{dir, int64(time.Since(r.start)), uint32(len(data))})
+	buf.Write(data)
+	_, r.err = r.w.Write(buf.Bytes())
+}
+
+// Err returns the first error encountered writing the recording, if any.
+// Recording stops after an error but the transport keeps working.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Name returns the name of the wrapped transport.
+func (r *Recorder) Name() string {
+	if t, ok := r.conn.(Transport); ok {
+		return t.Name()
+	}
+	return ""
+}
+
+func (r *Recorder) Read(data []byte) (int, error) {
+	n, err := r.conn.Read(data)
+	if n > 0 {
+		r.record(DirRead, data[:n])
+	}
+	return n, err
+}
+
+func (r *Recorder) Write(data []byte) (int, error) {
+	n, err := r.conn.Write(data)
+	if n > 0 {
+		r.record(DirWrite, data[:n])
+	}
+	return n, err
+}
+
+func (r *Recorder) Close() error {
+	return r.conn.Close()
+}
+
+// SetReadDeadline sets the read deadline of the wrapped transport. Returns
+// DeadlineUnsupportedError if it doesn't implement Deadliner.
+func (r *Recorder) SetReadDeadline(t time.Time) error {
+	if d, ok := r.conn.(Deadliner); ok {
+		return d.SetReadDeadline(t)
+	}
+	return DeadlineUnsupportedError
+}
+
+// SetWriteDeadline sets the write deadline of the wrapped transport. Returns
+// DeadlineUnsupportedError if it doesn't implement Deadliner.
+func (r *Recorder) SetWriteDeadline(t time.Time) error {
+	if d, ok := r.conn.(Deadliner); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return DeadlineUnsupportedError
+}
+
+// RecordingReader reads records from a recording.
+type RecordingReader struct {
+	r     *bufio.Reader
+	Start time.Time // Wall clock time the recording started
+}
+
+// NewRecordingReader reads the recording header from `r`.
+func NewRecordingReader(r io.Reader) (*RecordingReader, error) {
+	br := bufio.NewReader(r)
+
+	header := make([]byte, len(recordingMagic)+1+8)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("Could not read recording header: %v", err)
+	}
+	if string(header[:4]) != recordingMagic {
+		return nil, fmt.Errorf("Not a recording, expected magic %q but got %q", recordingMagic, header[:4])
+	}
+	if header[4] != recordingVersion {
+		return nil, fmt.Errorf("Unsupported recording version %d", header[4])
+	}
+
+	start := int64(binary.BigEndian.Uint64(header[5:]))
+	return &RecordingReader{br, time.Unix(0, start)}, nil
+}
+
+// Next returns the next record or io.EOF at the end of the recording.
+func (rr *RecordingReader) Next() (*Record, error) {
+	var h recordHeader
+	if err := binary.Read(rr.r, binary.BigEndian, &h); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("Truncated record header")
+		}
+		return nil, err
+	}
+	if h.Dir != DirRead && h.Dir != DirWrite {
+		return nil, fmt.Errorf("Corrupt record: unknown direction %d", uint8(h.Dir))
+	}
+	if h.Length > maxRecordLength {
+		return nil, fmt.Errorf("Corrupt record: length %d exceeds %d bytes", h.Length, maxRecordLength)
+	}
+
+	data := make([]byte, h.Length)
+	if _, err := io.ReadFull(rr.r, data); err != nil {
+		return nil, fmt.Errorf("Truncated record: expected %d bytes: %v", h.Length, err)
+	}
+	return &Record{h.Dir, time.Duration(h.Offset), data}, nil
+}
+
+// ReplayOptions configure a Replay.
+type ReplayOptions struct {
+	// Verify checks that the bytes written during replay match the recorded
+	// writes. A mismatching Write fails with ReplayMismatchError.
+	Verify bool
+
+	// Realtime delays reads to match the recorded timing. Otherwise reads are
+	// only held back until the writes recorded before them have happened.
+	Realtime bool
+}
+
+// Replay is a transport which plays a recording back, so a recorded session
+// can be reproduced without the robot:
+//
+//	replay, err := sphero.NewReplay(f, sphero.ReplayOptions{Verify: true})
+//	s := sphero.NewSpheroConn(replay, async)
+//
+// Each recorded read is delivered once the application has written as many
+// bytes as were written before it in the recording, so answers never arrive
+// ahead of the commands they answer. After the last record reads block until
+// the Replay is closed; Done reports when that point has been reached.
+type Replay struct {
+	opts    ReplayOptions
+	records []*Record
+	before  []int // Bytes written in the recording before each record
+	start   time.Time
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	next    int    // Index of the next record to read
+	pending []byte // Unread data of the current read record
+	written int    // Bytes written so far
+	want    []byte // Recorded writes, concatenated
+	closed  bool
+	done    chan struct{}
+}
+
+// NewReplay reads a whole recording from `r` and returns a transport that
+// plays it back.
+func NewReplay(r io.Reader, opts ReplayOptions) (*Replay, error) {
+	rr, err := NewRecordingReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rp := &Replay{
+		opts: opts,
+		done: make(chan struct{}),
+	}
+	rp.cond = sync.NewCond(&rp.mu)
+
+	for {
+		rec, err := rr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		rp.records = append(rp.records, rec)
+		rp.before = append(rp.before, len(rp.want))
+		if rec.Dir == DirWrite {
+			rp.want = append(rp.want, rec.Data...)
+		}
+	}
+
+	rp.start = time.Now()
+	rp.advance()
+	return rp, nil
+}
+
+// Name implements Transport.
+func (rp *Replay) Name() string {
+	return "replay"
+}
+
+// Done is closed once every recorded read has been delivered.
+func (rp *Replay) Done() <-chan struct{} {
+	return rp.done
+}
+
+// Skips over write records and closes `done` at the end of the recording.
+// Called with rp.mu held.
+func (rp *Replay) advance() {
+	for rp.next < len(rp.records) && rp.records[rp.next].Dir != DirRead {
+		rp.next++
+	}
+	if rp.next == len(rp.records) && len(rp.pending) == 0 {
+		select {
+		case <-rp.done:
+		default:
+			close(rp.done)
+		}
+	}
+}
+
+func (rp *Replay) Read(data []byte) (int, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	for len(rp.pending) == 0 {
+		if rp.closed {
+			return 0, io.ErrClosedPipe
+		}
+		if rp.next < len(rp.records) && rp.written >= rp.before[rp.next] {
+			rec := rp.records[rp.next]
+			if wait := rec.Offset - time.Since(rp.start); rp.opts.Realtime && wait > 0 {
+				rp.mu.Unlock()
+				time.Sleep(wait)
+				rp.mu.Lock()
+				continue
+			}
+			rp.pending = rec.Data
+			rp.next++
+			break
+		}
+		rp.cond.Wait()
+	}
+
+	n := copy(data, rp.pending)
+	rp.pending = rp.pending[n:]
+	rp.advance()
+	return n, nil
+}
+
+func (rp *Replay) Write(data []byte) (int, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if rp.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	if rp.opts.Verify {
+		for i, b := range data {
+			at := rp.written + i
+			if at >= len(rp.want) {
+				return i, fmt.Errorf("%w: unexpected write of %#x after the recorded writes", ReplayMismatchError, data[i:])
+			}
+			if rp.want[at] != b {
+				return i, fmt.Errorf("%w: expected %#x at byte %d but got %#x", ReplayMismatchError, rp.want[at], at, b)
+			}
+		}
+	}
+
+	rp.written += len(data)
+	rp.cond.Broadcast()
+	return len(data), nil
+}
+
+func (rp *Replay) Close() error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	rp.closed = true
+	rp.cond.Broadcast()
+	return nil
+}
@@ -0,0 +1,166 @@
+package sphero_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/FreeFlow/sphero"
+	"github.com/FreeFlow/sphero/spherosim"
+)
+
+// Sets a color, reads it back and waits for a collision.
+func session(t *testing.T, s *sphero.Sphero, async <-chan *sphero.AsyncResponse, collide func()) *sphero.Color {
+	ch := make(chan *sphero.Response, 1)
+	wait := func() *sphero.Response {
+		select {
+		case r := <-ch:
+			return r
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for response")
+		}
+		return nil
+	}
+
+	s.SetRGBLEDOutput(1, 2, 3, true, ch)
+	wait()
+	s.ConfigureCollisionDetection(0x01, 40, 40, 50, 50, 10, ch)
+	wait()
+	s.GetRGBLED(ch)
+	c, err := wait().Color()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collide()
+	select {
+	case r := <-async:
+		if r.IdCode != sphero.ID_COLLISION_DETECTED {
+			t.Fatalf("Unexpected async response %#v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for async response")
+	}
+	return c
+}
+
+func record(t *testing.T) []byte {
+	sim := spherosim.New()
+	defer sim.Close()
+
+	var buf bytes.Buffer
+	rec, err := sphero.NewRecorder(sim.Conn(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	async := make(chan *sphero.AsyncResponse, 1)
+	s := sphero.NewSpheroConn(rec, async)
+	session(t, s, async, func() { sim.Collide(sphero.Collision{}) })
+	s.Close()
+
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRecording(t *testing.T) {
+	rr, err := sphero.NewRecordingReader(bytes.NewReader(record(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reads, writes int
+	for {
+		rec, err := rr.Next()
+		if err != nil {
+			break
+		}
+		switch rec.Dir {
+		case sphero.DirRead:
+			reads++
+		case sphero.DirWrite:
+			writes++
+		}
+	}
+	if reads < 4 || writes != 3 {
+		t.Fatalf("Expected at least 4 reads and 3 writes but got %d and %d", reads, writes)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	replay, err := sphero.NewReplay(bytes.NewReader(record(t)), sphero.ReplayOptions{Verify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	async := make(chan *sphero.AsyncResponse, 1)
+	s := sphero.NewSpheroConn(replay, async)
+	defer s.Close()
+
+	c := session(t, s, async, func() {})
+	if *c != (sphero.Color{R: 1, G: 2, B: 3}) {
+		t.Fatalf("Unexpected color %#v", c)
+	}
+
+	select {
+	case <-replay.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Replay not done")
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	replay, err := sphero.NewReplay(bytes.NewReader(record(t)), sphero.ReplayOptions{Verify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replay.Close()
+
+	s := sphero.NewSpheroConn(replay, nil)
+	defer s.Close()
+
+	if err := s.SetRGBLEDOutput(3, 2, 1, true, nil); !errors.Is(err, sphero.ReplayMismatchError) {
+		t.Fatalf("Expected ReplayMismatchError but got %v", err)
+	}
+}
+
+func TestRecordingCorrupt(t *testing.T) {
+	header := append([]byte("SPRC\x01"), make([]byte, 8)...)
+	for name, rec := range map[string][]byte{
+		"direction": {0x05, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0xff},
+		"length":    {0x00, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff},
+	} {
+		rr, err := sphero.NewRecordingReader(bytes.NewReader(append(header[:13:13], rec...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rr.Next(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestRecorderDeadlines(t *testing.T) {
+	client, device := net.Pipe()
+	defer device.Close()
+
+	rec, err := sphero.NewRecorder(client, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := sphero.NewSpheroConn(rec, nil)
+	defer s.Close()
+
+	if err := s.SetWriteDeadline(time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("Expected the deadline to reach the wrapped conn but got %v", err)
+	}
+	if err := s.Ping(nil, sphero.ModeNoAnswer); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Expected os.ErrDeadlineExceeded but got %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package sphero
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SelfLevelOptions configure SelfLevel. Zero values select the firmware
+// defaults.
+type SelfLevelOptions struct {
+	FinalAngle    bool // Rotate to heading 0 when done
+	Sleep         bool // Go to sleep when done
+	ControlSystem bool // Leave the control system on when done
+
+	// How close to level counts as level, 1 to 90 degrees.
+	AngleLimit uint8
+
+	// How long to try before giving up, rounded to seconds, up to 255s.
+	Timeout time.Duration
+
+	// How long the Sphero must stay level, rounded to 10ms, up to 2.55s.
+	TrueTime time.Duration
+}
+
+// SelfLevelResult is the outcome of self leveling, see SelfLevelContext.
+type SelfLevelResult uint8
+
+const (
+	SelfLevelUnknown      SelfLevelResult = 0x00
+	SelfLevelTimedOut     SelfLevelResult = 0x01 // Level wasn't achieved in time
+	SelfLevelSensorsError SelfLevelResult = 0x02
+	SelfLevelDisabled     SelfLevelResult = 0x03 // Self leveling is disabled in the option flags
+	SelfLevelAborted      SelfLevelResult = 0x04 // By AbortSelfLevel
+	SelfLevelNoCharger    SelfLevelResult = 0x05 // Charger not found
+	SelfLevelSuccess      SelfLevelResult = 0x06
+)
+
+func (r SelfLevelResult) String() string {
+	switch r {
+	case SelfLevelUnknown:
+		return "unknown"
+	case SelfLevelTimedOut:
+		return "timed out"
+	case SelfLevelSensorsError:
+		return "sensors error"
+	case SelfLevelDisabled:
+		return "disabled"
+	case SelfLevelAborted:
+		return "aborted"
+	case SelfLevelNoCharger:
+		return "charger not found"
+	case SelfLevelSuccess:
+		return "success"
+	}
+	return fmt.Sprintf("SelfLevelResult(%d)", uint8(r))
+}
+
+// SelfLevel starts leveling the Sphero's control system, which takes a few
+// seconds. The outcome arrives as an ID_SELF_LEVEL_RESULT async response,
+// see AsyncResponse.SelfLevelResult, or use SelfLevelContext to wait for it.
+func (s *Sphero) SelfLevel(opts SelfLevelOptions, res chan<- *Response, mode ...SendMode) error {
+	data, err := selfLevelData(opts, true)
+	if err != nil {
+		return err
+	}
+	return s.Send(DID_SPHERO, CMD_SELF_LEVEL, data, res, mode...)
+}
+
+// AbortSelfLevel stops self leveling in progress, which then ends with
+// SelfLevelAborted.
+func (s *Sphero) AbortSelfLevel(res chan<- *Response, mode ...SendMode) error {
+	data, _ := selfLevelData(SelfLevelOptions{}, false)
+	return s.Send(DID_SPHERO, CMD_SELF_LEVEL, data, res, mode...)
+}
+
+// SelfLevelContext self levels the Sphero and waits for the outcome. If
+// `ctx` ends first self leveling is aborted and the context's error
+// returned. The result is also delivered to the async channel as usual.
+func (s *Sphero) SelfLevelContext(ctx context.Context, opts SelfLevelOptions, mode ...SendMode) (SelfLevelResult, error) {
+	data, err := selfLevelData(opts, true)
+	if err != nil {
+		return SelfLevelUnknown, err
+	}
+
+	// Wait from before sending, the result may beat the answer.
+	ch, stop := s.waitAsync(ID_SELF_LEVEL_RESULT)
+	defer stop()
+	if _, err := s.Do(ctx, DID_SPHERO, CMD_SELF_LEVEL, data, mode...); err != nil {
+		return SelfLevelUnknown, err
+	}
+
+	select {
+	case r := <-ch:
+		return r.SelfLevelResult()
+	case <-ctx.Done():
+		s.AbortSelfLevel(nil, ModeNoAnswer)
+		return SelfLevelUnknown, ctx.Err()
+	case <-s.done:
+		return SelfLevelUnknown, s.Err()
+	}
+}
+
+func selfLevelData(opts SelfLevelOptions, start bool) ([]byte, error) {
+	var options uint8
+	for _, o := range []struct {
+		set  bool
+		flag uint8
+	}{
+		{start, SELF_LEVEL_START},
+		{opts.FinalAngle, SELF_LEVEL_FINAL_ANGLE},
+		{opts.Sleep, SELF_LEVEL_SLEEP},
+		{opts.ControlSystem, SELF_LEVEL_CONTROL_SYSTEM},
+	} {
+		if o.set {
+			options |= o.flag
+		}
+	}
+
+	timeout := opts.Timeout.Round(time.Second) / time.Second
+	if timeout < 0 || timeout > 0xff {
+		return nil, fmt.Errorf("%w: self level timeout is %v, must be at most 255s", FieldRangeError, opts.Timeout)
+	}
+	trueTime := opts.TrueTime.Round(10*time.Millisecond) / (10 * time.Millisecond)
+	if trueTime < 0 || trueTime > 0xff {
+		return nil, fmt.Errorf("%w: self level true time is %v, must be at most 2.55s", FieldRangeError, opts.TrueTime)
+	}
+	return mustCommand(DID_SPHERO, CMD_SELF_LEVEL).EncodeRequest(options, opts.AngleLimit, uint8(timeout), uint8(trueTime))
+}
+
+// Registers to receive the next async response with ID `id`, alongside the
+// async channel. Call `stop` once done waiting.
+func (s *Sphero) waitAsync(id uint8) (ch <-chan *AsyncResponse, stop func()) {
+	c := make(chan *AsyncResponse, 1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.waiters == nil {
+		s.waiters = make(map[uint8][]chan<- *AsyncResponse)
+	}
+	s.waiters[id] = append(s.waiters[id], c)
+
+	return c, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		ws := s.waiters[id]
+		for i, w := range ws {
+			if w == c {
+				s.waiters[id] = append(ws[:i:i], ws[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Hands an async response to everything waiting for its ID. Waiters are
+// single-use.
+func (s *Sphero) notifyAsync(r *AsyncResponse) {
+	s.mu.Lock()
+	ws := s.waiters[r.IdCode]
+	delete(s.waiters, r.IdCode)
+	s.mu.Unlock()
+
+	for _, w := range ws {
+		w <- r // Buffered and only ever sent once
+	}
+}
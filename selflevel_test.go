@@ -0,0 +1,90 @@
+package sphero_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/FreeFlow/sphero"
+	"github.com/FreeFlow/sphero/spherosim"
+)
+
+func TestSelfLevel(t *testing.T) {
+	sim, s, async := connect(t)
+	ctx := timeout(t, time.Second)
+
+	opts := sphero.SelfLevelOptions{
+		FinalAngle: true,
+		AngleLimit: 3,
+		Timeout:    10 * time.Second,
+		TrueTime:   500 * time.Millisecond,
+	}
+	result, err := s.SelfLevelContext(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != sphero.SelfLevelSuccess {
+		t.Fatalf("Expected success but got %v", result)
+	}
+	if st := sim.State(); st.SelfLevel != (spherosim.SelfLevel{Options: 0x03, AngleLimit: 3, Timeout: 10, TrueTime: 50}) {
+		t.Fatalf("Unexpected self level request %+v", st.SelfLevel)
+	}
+
+	// The result still reaches the async channel.
+	select {
+	case r := <-async:
+		if res, err := r.SelfLevelResult(); err != nil || res != sphero.SelfLevelSuccess {
+			t.Fatalf("Unexpected async result %v, %v", res, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the async result")
+	}
+
+	sim.SetSelfLevelResult(sphero.SelfLevelNoCharger, false)
+	if result, err := s.SelfLevelContext(ctx, sphero.SelfLevelOptions{}); err != nil || result != sphero.SelfLevelNoCharger {
+		t.Fatalf("Expected %v but got %v, %v", sphero.SelfLevelNoCharger, result, err)
+	}
+}
+
+func TestSelfLevelCancel(t *testing.T) {
+	sim, s, _ := connect(t)
+	sim.SetSelfLevelResult(sphero.SelfLevelSuccess, true)
+	ctx := timeout(t, 50*time.Millisecond)
+
+	if _, err := s.SelfLevelContext(ctx, sphero.SelfLevelOptions{}); err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded but got %v", err)
+	}
+
+	// Self leveling was aborted on the way out.
+	deadline := time.Now().Add(time.Second)
+	for sim.State().SelfLevel.Options&sphero.SELF_LEVEL_START != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Self leveling wasn't aborted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSelfLevelOptions(t *testing.T) {
+	_, s, _ := connect(t)
+	for _, opts := range []sphero.SelfLevelOptions{
+		{AngleLimit: 91},
+		{Timeout: 256 * time.Second},
+		{Timeout: -time.Second},
+		{TrueTime: 3 * time.Second},
+	} {
+		if err := s.SelfLevel(opts, nil); !errors.Is(err, sphero.FieldRangeError) {
+			t.Errorf("%+v: expected FieldRangeError but got %v", opts, err)
+		}
+	}
+
+	r := &sphero.AsyncResponse{IdCode: sphero.ID_COLLISION_DETECTED, Data: []byte{0x06}}
+	if _, err := r.SelfLevelResult(); !errors.Is(err, sphero.ResponseMismatchError) {
+		t.Fatalf("Expected ResponseMismatchError but got %v", err)
+	}
+	r = &sphero.AsyncResponse{IdCode: sphero.ID_SELF_LEVEL_RESULT, Data: []byte{0x06, 0x00}}
+	if _, err := r.SelfLevelResult(); !errors.Is(err, sphero.PayloadLayoutError) {
+		t.Fatalf("Expected PayloadLayoutError but got %v", err)
+	}
+}
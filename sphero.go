@@ -3,313 +3,467 @@
 package sphero
 
 import (
-	"bytes"
-	"encoding/binary"
-	"fmt"
-	serial "github.com/FreeFlow/goserial"
+	"errors"
 	"io"
-	"os"
-	"syscall"
+	"sync"
 	"time"
+
+	"github.com/FreeFlow/sphero/codec"
 )
 
 // Sphero represents a connection to a single Sphero robot.
 type Sphero struct {
+	wmu sync.Mutex // Serializes frame writes
+
+	mu    sync.Mutex // Guards the fields below
 	conn  io.ReadWriteCloser
 	seq   uint8
-	res   map[uint8]chan<- *Response
-	kill  chan struct{}
+	res   map[uint8]*pending
 	async chan<- *AsyncResponse
+
+	done    chan struct{} // Closed once the connection has ended
+	expired chan struct{} // Closed deliveryGrace after done
+	err     error         // Why the connection ended
+	onError func(error)   // Receives non-fatal errors
+	timeout time.Duration // How long response slots wait for an answer
+	logger  Logger
+	trace   bool // Log every frame
+
+	writeDeadline time.Time // Set with SetWriteDeadline
+
+	disconnected bool                               // Waiting to reconnect
+	redial       func() (io.ReadWriteCloser, error) // Reopens the transport, may be nil
+	reconnect    *ReconnectOptions                  // Nil unless reconnect is enabled
+	config       map[uint16][]byte                  // Last payload of restorable commands
+	attempt      int                                // Reconnect attempts since the link dropped
+	restoreErr   error                              // Why restoring over the current transport failed
+
+	heading   uint16 // Heading of the last Roll, held by Stop
+	rawMotors bool   // Raw motor mode, see RawMotorMode
+
+	waiters   map[uint8][]chan<- *AsyncResponse // Waiting for async IDs, see waitAsync
+	dropAsync bool                              // See SetDropAsync
+
+	stats linkStats
 }
 
 // NewSphero creates and initializes a Sphero connection. It will attempt
 // to connect to the device at the `name` provided or throw an error if
 // this fails.
 func NewSphero(name string, async chan<- *AsyncResponse) (*Sphero, error) {
-	conf := &serial.Config{
-		Name: name,
-		Baud: 115200,
-	}
-
-	var conn io.ReadWriteCloser
-	var err error
-	if conn, err = serial.OpenPort(conf); err != nil {
+	conn, err := OpenSerial(name)
+	if err != nil {
 		return nil, err
 	}
+	return newSphero(conn, async, func() (io.ReadWriteCloser, error) {
+		return OpenSerial(name)
+	}), nil
+}
+
+// NewSpheroConn creates a Sphero connection over an already opened
+// transport, e.g. a pty, a socket or a test double. The Sphero takes
+// ownership of `conn` and closes it on `Close`. If `async` is nil async
+// responses are discarded. The connection waits for `async` to take each
+// async response, see SetDropAsync to drop them instead.
+func NewSpheroConn(conn io.ReadWriteCloser, async chan<- *AsyncResponse) *Sphero {
+	return newSphero(conn, async, nil)
+}
 
+func newSphero(conn io.ReadWriteCloser, async chan<- *AsyncResponse, redial func() (io.ReadWriteCloser, error)) *Sphero {
 	s := &Sphero{
-		conn:  conn,
-		seq:   0,
-		res:   make(map[uint8]chan<- *Response),
-		kill:  make(chan struct{}, 1),
-		async: async,
+		conn:    conn,
+		seq:     0,
+		res:     make(map[uint8]*pending),
+		timeout: DefaultResponseTimeout,
+		done:    make(chan struct{}),
+		expired: make(chan struct{}),
+		async:   async,
+		redial:  redial,
+		config:  make(map[uint16][]byte),
 	}
 
 	go s.listen()
 
-	return s, nil
+	return s
 }
 
-// Parse processes an incoming response.
-func (s *Sphero) parse(buf []byte) (n int, err error) {
-	sop1 := buf[0]
-
-	if sop1 != SOP1 {
-		err = fmt.Errorf("SOP1 must be FFh but got %p", sop1)
-		n = 1 // Chomp 1 byte and maybe we'll recover
-		return
-	}
-
-	sop2 := buf[1]
-
-	switch sop2 {
-	case SOP2_ANSWER:
-		r := new(Response)
-		r.Sop1 = sop1
-		r.Sop2 = sop2
-		r.Mrsp = buf[2]
-
-		packetBuf := bytes.NewBuffer(buf)
-
-		// Skip the SOP bytes and MRSP
-		packetBuf.Next(3)
-
-		binary.Read(packetBuf, binary.BigEndian, &r.Seq)
-		binary.Read(packetBuf, binary.BigEndian, &r.Dlen)
-
-		dataStart := 5
-
-		/*
-			We haven't read enough data yet to finish parsing this message, we'll wait
-			for more.
-		*/
-		if len(buf) < int(r.Dlen)+dataStart {
-			return
+// Handles an incoming answer or async packet.
+func (s *Sphero) handle(p codec.Packet) {
+	switch p := p.(type) {
+	case *codec.AnswerPacket:
+		r := &Response{
+			Sop1: SOP1,
+			Sop2: SOP2_ANSWER,
+			Mrsp: p.Mrsp,
+			Seq:  p.Seq,
+			Dlen: uint8(len(p.Data) + 1),
+			Data: p.Data,
 		}
+		r.Chk = codec.Checksum(append([]byte{r.Mrsp, r.Seq, r.Dlen}, r.Data...))
+		r.Received = time.Now()
 
-		dataEnd := dataStart + (int(r.Dlen) - 1)
-		r.Data = buf[dataStart:dataEnd]
-
-		chkBytes := buf[2:dataEnd]
-		computedChk := computeChk(chkBytes)
-
-		// Skip over the data bytes
-		packetBuf.Next(int(r.Dlen) - 1)
-		binary.Read(packetBuf, binary.BigEndian, &r.Chk)
-
-		n = int(dataEnd) + 1
-
-		/*
-			Verify the check matches what we expect. If it doesn't match we return an
-			error and let the listener throw away the bad data.
-		*/
-		if computedChk != r.Chk {
-			err = fmt.Errorf("Invalid check: expected %#x but got %#x", r.Chk, computedChk)
-			return
-		}
+		s.traceAnswer(r)
 
 		/*
 			Send the response over the channel associated with the seq number, if it
-			exists.
+			exists. The slot is single-use so the seq number can be reused.
 		*/
-		if res, ok := s.res[r.Seq]; ok {
-			res <- r
-		}
-	case SOP2_ASYNC:
-		if len(buf) < 7 {
+		s.mu.Lock()
+		slot, ok := s.res[r.Seq]
+		delete(s.res, r.Seq)
+		s.mu.Unlock()
+		if !ok {
+			s.stats.answer(r, false)
 			return
 		}
-
-		r := new(AsyncResponse)
-		r.Sop1 = sop1
-		r.Sop2 = sop2
-		r.IdCode = buf[2]
-
-		packetBuf := bytes.NewBuffer(buf)
-
-		// Skip the SOP bytes and ID CODE
-		packetBuf.Next(3)
-
-		binary.Read(packetBuf, binary.BigEndian, &r.Dlen)
-
-		dataStart := 5
-
-		if len(buf) < int(r.Dlen)+dataStart {
-			return
+		slot.stop()
+		slot.bind(r)
+		s.stats.answer(r, true)
+		s.deliver(slot.ch, r)
+	case *codec.AsyncPacket:
+		r := &AsyncResponse{
+			Sop1:   SOP1,
+			Sop2:   SOP2_ASYNC,
+			IdCode: p.IdCode,
+			Dlen:   uint16(len(p.Data) + 1),
+			Data:   p.Data,
 		}
+		r.Chk = codec.Checksum(append([]byte{r.IdCode, uint8(r.Dlen >> 8), uint8(r.Dlen)}, r.Data...))
 
-		dataEnd := dataStart + (int(r.Dlen) - 1)
-		r.Data = buf[dataStart:dataEnd]
-
-		chkBytes := buf[2:dataEnd]
-		computedChk := computeChk(chkBytes)
+		s.traceAsync(r)
+		s.notifyAsync(r)
 
-		// Skip over the data bytes
-		packetBuf.Next(int(r.Dlen) - 1)
-		binary.Read(packetBuf, binary.BigEndian, &r.Chk)
+		s.mu.Lock()
+		drop := s.dropAsync
+		s.mu.Unlock()
 
-		n = int(dataEnd) + 1
-
-		/*
-			Verify the check matches what we expect. If it doesn't match we return an
-			error and let the listener throw away the bad data.
-		*/
-		if computedChk != r.Chk {
-			err = fmt.Errorf("Invalid async check: expected %#x but got %#x", r.Chk, computedChk)
-			return
+		dropped := false
+		if s.async != nil && drop {
+			select {
+			case s.async <- r:
+			default:
+				dropped = true
+			}
+		} else if s.async != nil {
+			select {
+			case s.async <- r:
+			case <-s.done:
+			}
 		}
-
-		s.async <- r
-	default:
-		n = 1 // Chomp 1 byte and maybe we'll recover
-		err = fmt.Errorf("Unexpected SOP2, should be %#x or %#x but got %#x", SOP2_ANSWER, SOP2_ASYNC, sop2)
+		s.stats.update(func(st *Stats) {
+			st.Async++
+			if dropped {
+				st.AsyncDropped++
+			}
+		})
 	}
-	return
 }
 
 func (s *Sphero) listen() {
-	var data []byte
-	var buf []byte
-	var n int
-	var err error
+	dec := codec.NewDecoder(readerFunc(s.Read))
+	var base, last codec.Stats // Stats from replaced decoders, and from dec when last seen
 
 	for {
 		select {
-		case <-s.kill:
+		case <-s.done:
 			return
 		default:
-			data = make([]byte, 256)
-
-			/*
-				Since EOF errors are expected when the Sphero indicates it doesn't
-				expect to send more data (e.g. all responses have been sent for commands
-				received so far and async responses are turned off).
-
-				EBADF errors are also expected if we've initiated a `Close` while `Read`
-				was blocking.
-			*/
-			if n, err = s.Read(data); err != nil && err != io.EOF {
-				if pathErr, ok := err.(*os.PathError); ok {
-					if pathErr.Err != syscall.EBADF {
-						panic(pathErr)
-					}
-				} else {
-					panic(err)
+		}
+
+		p, err := dec.Decode()
+		if st := dec.Stats(); st != last {
+			last = st
+			s.stats.update(func(stats *Stats) {
+				stats.Framing = codec.Stats{
+					Discarded:      base.Discarded + st.Discarded,
+					ChecksumErrors: base.ChecksumErrors + st.ChecksumErrors,
+					Recovered:      base.Recovered + st.Recovered,
 				}
-			}
+			})
+		}
 
-			/*
-				Trim the 256 byte data by the number of bytes actually read and append
-				it to our buffer.
-			*/
-			if n > 0 {
-				data = data[:n]
-				buf = append(buf, data...)
-			}
+		/*
+			Malformed frames have already been skipped by the decoder, we report
+			them and carry on with the next one.
+		*/
+		var frameErr *codec.FrameError
+		if errors.As(err, &frameErr) {
+			s.reportError(err)
+			continue
+		}
 
-			/*
-				If our buffer is too short to form a meaningful response, we wait until
-				we've read more. Answers need to be at least 6 bytes, async responses
-				need to be at least 7.
-			*/
-			if len(buf) < 6 {
-				continue
-			}
+		/*
+			Read deadlines passing are expected, see isTransientReadError.
 
-			if n, err = s.parse(buf); err != nil {
-				fmt.Println(err)
+			Any other error is expected if we've initiated a `Close` while `Read`
+			was blocking, otherwise the link is gone. Unless we can reconnect the
+			connection fails with that error. A transport closed because restoring
+			the configuration over it failed is gone too, whatever its error.
+		*/
+		if err == io.EOF {
+			err = ConnectionLostError // The other end closed the stream
+		}
+		lost := err != nil && !isTransientReadError(err)
+		if err != nil {
+			s.mu.Lock()
+			if s.restoreErr != nil {
+				err, lost = s.restoreErr, true
+			}
+			s.mu.Unlock()
+		}
+		if lost {
+			select {
+			case <-s.done:
+				return // Closed while reading
+			default:
 			}
 
-			// Trim our buffer by the number of bytes successfully parsed.
-			if n > 0 {
-				buf = buf[n:]
+			s.mu.Lock()
+			reconnect := s.reconnect != nil
+			s.mu.Unlock()
+			if !reconnect || !s.reconnectLoop(err) {
+				s.shutdown(err)
+				return
 			}
+
+			// Partial frames from the old link are useless
+			dec = codec.NewDecoder(readerFunc(s.Read))
+			base, last = s.Stats().Framing, codec.Stats{}
+			continue
+		}
+
+		if p != nil {
+			s.handle(p)
 		}
 	}
 }
 
+// Name returns the name of the underlying transport, e.g. the serial device
+// path, or an empty string if the transport doesn't implement Transport.
+func (s *Sphero) Name() string {
+	if t, ok := s.transport().(Transport); ok {
+		return t.Name()
+	}
+	return ""
+}
+
+// SetDropAsync chooses what happens to async responses the async channel
+// can't take right away. By default the listener waits until the channel
+// takes them, holding up answers meanwhile. With `on` they are dropped
+// instead and counted in Stats.AsyncDropped.
+func (s *Sphero) SetDropAsync(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropAsync = on
+}
+
+// SetWriteDeadline sets the deadline for future writes to the transport.
+// Returns DeadlineUnsupportedError if the transport doesn't implement
+// Deadliner.
+func (s *Sphero) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.conn.(Deadliner); ok {
+		s.writeDeadline = t
+		return d.SetWriteDeadline(t)
+	}
+	return DeadlineUnsupportedError
+}
+
+// SetReadDeadline sets the deadline for future reads from the transport.
+// Reads that time out are retried by the listener.
+// Returns DeadlineUnsupportedError if the transport doesn't implement
+// Deadliner.
+func (s *Sphero) SetReadDeadline(t time.Time) error {
+	if d, ok := s.transport().(Deadliner); ok {
+		return d.SetReadDeadline(t)
+	}
+	return DeadlineUnsupportedError
+}
+
+// Returns the current transport, which changes when reconnecting.
+func (s *Sphero) transport() io.ReadWriteCloser {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn
+}
+
 // Implement io.ReadWriteCloser
 
 // Implement io.Closer
 func (s *Sphero) Close() error {
-	s.kill <- struct{}{} // Signal to kill our goroutine
-	return s.conn.Close()
+	return s.shutdown(ClosedError)
 }
 
 // Implement io.Writer
 func (s *Sphero) Write(data []byte) (int, error) {
-	return s.conn.Write(data)
+	n, err := s.transport().Write(data)
+	s.stats.update(func(st *Stats) { st.BytesSent += uint64(n) })
+	return n, err
 }
 
 // Implement io.Reader
 func (s *Sphero) Read(data []byte) (int, error) {
-	return s.conn.Read(data)
+	n, err := s.transport().Read(data)
+	if n > 0 {
+		s.stats.update(func(st *Stats) { st.BytesReceived += uint64(n) })
+	}
+	return n, err
 }
 
-func (s *Sphero) Send(did, cid uint8, data []byte, res chan<- *Response) error {
-	s.seq++
-	if res != nil {
-		s.res[s.seq] = res
-	}
+// Send sends a raw command to the Sphero. The answer, if any, is delivered
+// on `res`, waiting for the channel to take it while the connection lasts
+// and for at most a second after it ends. Like every command method it
+// takes an optional SendMode, ModeAnswer by default. While reconnecting,
+// Send fails with DisconnectedError, though configuration commands are still
+// remembered and applied once the connection is restored.
+func (s *Sphero) Send(did, cid uint8, data []byte, res chan<- *Response, mode ...SendMode) error {
+	_, err := s.send(did, cid, data, res, sendMode(mode), time.Time{})
+	return err
+}
 
-	var buf bytes.Buffer
-	buf.Write([]byte{SOP1})                                  // SOP1
-	buf.Write([]byte{SOP2_ANSWER})                           // SOP2
-	buf.Write([]byte{did})                                   // DID
-	buf.Write([]byte{cid})                                   // CID
-	binary.Write(&buf, binary.BigEndian, s.seq)              // SEQ
-	binary.Write(&buf, binary.BigEndian, uint8(len(data)+1)) // DLEN
+// Sends a command, returning the seq number used. Commands expecting an
+// answer get a response slot even if `res` is nil, so the answer is matched
+// and timed. Commands sent without an answer don't use up a seq number or a
+// response slot, `res` is ignored. A non-zero `deadline` bounds the write on
+// transports implementing Deadliner.
+func (s *Sphero) send(did, cid uint8, data []byte, res chan<- *Response, mode SendMode, deadline time.Time) (uint8, error) {
+	if !mode.valid() {
+		return 0, InvalidSendModeError
+	}
 
-	if data != nil {
-		buf.Write(data)
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return 0, s.err
+	}
+	if restorable(did, cid) {
+		s.config[cmdKey(did, cid)] = append([]byte(nil), data...)
+	}
+	s.trackMotors(did, cid, data)
+	if s.disconnected {
+		s.mu.Unlock()
+		return 0, DisconnectedError
+	}
+	var seq uint8
+	var err error
+	if mode.Answer() {
+		if seq, err = s.nextSeq(); err != nil {
+			s.mu.Unlock()
+			return 0, err
+		}
+		s.res[seq] = s.newPending(seq, did, cid, res)
+	} else {
+		res = nil
+	}
+	s.mu.Unlock()
+
+	frame, err := (&codec.CommandPacket{
+		Sop2: byte(mode),
+		Did:  did,
+		Cid:  cid,
+		Seq:  seq,
+		Data: data,
+	}).MarshalBinary()
+	if err != nil {
+		if mode.Answer() {
+			s.release(seq, res)
+		}
+		return 0, err
 	}
 
-	chk := computeChk(buf.Bytes()[2:buf.Len()])
-	binary.Write(&buf, binary.BigEndian, chk) // DLEN
+	s.wmu.Lock()
+	err = s.writeFrame(frame, deadline)
+	s.wmu.Unlock()
+
+	if err != nil {
+		if mode.Answer() {
+			s.release(seq, res)
+		}
+		return 0, err
+	}
+	s.stats.update(func(st *Stats) { st.CommandsSent++ })
+	s.traceCommand(byte(mode), did, cid, seq, data)
+	return seq, nil
+}
 
-	_, err := s.Write(buf.Bytes())
+// Writes a frame, giving up at `deadline` if it's earlier than the one set
+// with SetWriteDeadline and the transport supports deadlines. Called with
+// s.wmu held.
+func (s *Sphero) writeFrame(frame []byte, deadline time.Time) error {
+	s.mu.Lock()
+	d, ok := s.conn.(Deadliner)
+	user := s.writeDeadline
+	s.mu.Unlock()
+
+	if ok && !deadline.IsZero() && (user.IsZero() || deadline.Before(user)) {
+		d.SetWriteDeadline(deadline)
+		defer func() {
+			s.mu.Lock()
+			d.SetWriteDeadline(s.writeDeadline)
+			s.mu.Unlock()
+		}()
+	}
+	_, err := s.Write(frame)
 	return err
 }
 
+// Allocates the next seq number. After wrapping around, seq numbers still
+// waiting for an answer are skipped so answers can't be misrouted. Called
+// with s.mu held.
+func (s *Sphero) nextSeq() (uint8, error) {
+	for i := 0; i < 256; i++ {
+		s.seq++
+		if _, ok := s.res[s.seq]; !ok {
+			return s.seq, nil
+		}
+	}
+	return 0, SequenceExhaustedError
+}
+
 // Device: Core
 
-func (s *Sphero) Ping(res chan<- *Response) error {
-	return s.Send(DID_CORE, CMD_PING, nil, res)
+func (s *Sphero) Ping(res chan<- *Response, mode ...SendMode) error {
+	return s.Send(DID_CORE, CMD_PING, nil, res, mode...)
+}
+
+// Gets the versions of the Sphero's hardware and software. See
+// `Response.Version()`.
+func (s *Sphero) GetVersion(res chan<- *Response, mode ...SendMode) error {
+	return s.Send(DID_CORE, CMD_VERSION, nil, res, mode...)
+}
+
+func (s *Sphero) Sleep(wakeup time.Duration, macro uint8, orbBasic uint16, res chan<- *Response, mode ...SendMode) error {
+	return s.Send(DID_CORE, CMD_SLEEP, sleepData(wakeup, macro, orbBasic), res, mode...)
 }
 
-func (s *Sphero) Sleep(wakeup time.Duration, macro uint8, orbBasic uint16, res chan<- *Response) error {
-	var data bytes.Buffer
-	binary.Write(&data, binary.BigEndian, uint16(wakeup))
-	binary.Write(&data, binary.BigEndian, macro)
-	binary.Write(&data, binary.BigEndian, orbBasic)
-	return s.Send(DID_CORE, CMD_SLEEP, data.Bytes(), res)
+func sleepData(wakeup time.Duration, macro uint8, orbBasic uint16) []byte {
+	return encode(DID_CORE, CMD_SLEEP, uint16(wakeup), macro, orbBasic)
 }
 
 // Device: Sphero
 
-func (s *Sphero) SetHeading(heading int16, res chan<- *Response) error {
-	if heading > 359 || heading < 0 {
-		return fmt.Errorf("Invalid heading: %d - must be between 0 and 359 (inclusive)", heading)
+// Adjusts the Sphero's zero heading by `heading` degrees, 0 to 359. With
+// stabilization on the Sphero turns to the new heading. See
+// BeginCalibration for aiming the Sphero interactively.
+func (s *Sphero) SetHeading(heading int16, res chan<- *Response, mode ...SendMode) error {
+	data, err := headingData(heading)
+	if err != nil {
+		return err
 	}
-	var data bytes.Buffer
-	binary.Write(&data, binary.BigEndian, heading)
-	return s.Send(DID_CORE, CMD_SLEEP, data.Bytes(), res)
+	return s.Send(DID_SPHERO, CMD_SET_CAL, data, res, mode...)
 }
 
-func (s *Sphero) SetStabilization(flag bool, res chan<- *Response) error {
-	data := make([]byte, 1)
-	if flag {
-		data[0] = 0x01
-	}
-	return s.Send(DID_SPHERO, CMD_SET_STABILIZ, data, res)
+func headingData(heading int16) ([]byte, error) {
+	return mustCommand(DID_SPHERO, CMD_SET_CAL).EncodeRequest(heading)
 }
 
-func (s *Sphero) SetRotationRate(rate uint8, res chan<- *Response) error {
-	return NotImplementedError
+func (s *Sphero) SetStabilization(flag bool, res chan<- *Response, mode ...SendMode) error {
+	return s.Send(DID_SPHERO, CMD_SET_STABILIZ, stabilizationData(flag), res, mode...)
 }
 
-func (s *Sphero) SelfLevel() error {
-	return NotImplementedError
+func stabilizationData(flag bool) []byte {
+	return encode(DID_SPHERO, CMD_SET_STABILIZ, flag)
 }
 
 /*
@@ -320,92 +474,67 @@ func (s *Sphero) SelfLevel() error {
 	pcnt - Packet count 1-255 (or 0 for unlimited streaming)
 	masks2 - See const.go for valid masks
 */
-func (s *Sphero) SetDataStreaming(n, m int16, pcnt uint8, masks []uint32, masks2 []uint32, res chan<- *Response) error {
-	var mask, mask2 uint32
-	if masks != nil {
-		mask = applyMasks32(masks)
-	}
-	if masks2 != nil {
-		mask2 = applyMasks32(masks2)
-	}
+func (s *Sphero) SetDataStreaming(n, m int16, pcnt uint8, masks []uint32, masks2 []uint32, res chan<- *Response, mode ...SendMode) error {
+	return s.Send(DID_SPHERO, CMD_SET_DATA_STREAMING, dataStreamingData(n, m, pcnt, masks, masks2), res, mode...)
+}
 
-	var data bytes.Buffer
-	binary.Write(&data, binary.BigEndian, n)
-	binary.Write(&data, binary.BigEndian, m)
-	binary.Write(&data, binary.BigEndian, mask)
-	binary.Write(&data, binary.BigEndian, pcnt)
-	binary.Write(&data, binary.BigEndian, mask2)
-	return s.Send(DID_SPHERO, CMD_SET_DATA_STREAMING, data.Bytes(), res)
+func dataStreamingData(n, m int16, pcnt uint8, masks []uint32, masks2 []uint32) []byte {
+	return encode(DID_SPHERO, CMD_SET_DATA_STREAMING, uint16(n), uint16(m), applyMasks32(masks), pcnt, applyMasks32(masks2))
 }
 
 /*
 	ConfigureCollisionDetection
 	method - Currently this must be either 0x01 (enabled) or 0x00 (disabled)
 */
-func (s *Sphero) ConfigureCollisionDetection(method, xThreshold, yThreshold, xSpeed, ySpeed, deadTime uint8, res chan<- *Response) error {
-	var data bytes.Buffer
-	binary.Write(&data, binary.BigEndian, method)
-	binary.Write(&data, binary.BigEndian, xThreshold)
-	binary.Write(&data, binary.BigEndian, xSpeed)
-	binary.Write(&data, binary.BigEndian, yThreshold)
-	binary.Write(&data, binary.BigEndian, ySpeed)
-	binary.Write(&data, binary.BigEndian, deadTime)
-	return s.Send(DID_SPHERO, CMD_SET_COLLISION_DET, data.Bytes(), res)
-}
-
-func (s *Sphero) ConfigureLocator(flags uint8, x, y, yawTare uint16, res chan<- *Response) error {
-	return NotImplementedError
+func (s *Sphero) ConfigureCollisionDetection(method, xThreshold, yThreshold, xSpeed, ySpeed, deadTime uint8, res chan<- *Response, mode ...SendMode) error {
+	data := collisionDetectionData(method, xThreshold, yThreshold, xSpeed, ySpeed, deadTime)
+	return s.Send(DID_SPHERO, CMD_SET_COLLISION_DET, data, res, mode...)
 }
 
-func (s *Sphero) ReadLocator(res chan<- *Response) error {
-	return NotImplementedError
+func collisionDetectionData(method, xThreshold, yThreshold, xSpeed, ySpeed, deadTime uint8) []byte {
+	return encode(DID_SPHERO, CMD_SET_COLLISION_DET, method, xThreshold, xSpeed, yThreshold, ySpeed, deadTime)
 }
 
-func (s *Sphero) SetRGBLEDOutput(red, green, blue uint8, flag bool, res chan<- *Response) error {
-	var data bytes.Buffer
-	binary.Write(&data, binary.BigEndian, red)
-	binary.Write(&data, binary.BigEndian, green)
-	binary.Write(&data, binary.BigEndian, blue)
+func (s *Sphero) ConfigureLocator(flags uint8, x, y, yawTare uint16, res chan<- *Response, mode ...SendMode) error {
+	return NotImplementedError
+}
 
-	// User flag - sets "user LED color"
-	if flag {
-		data.Write([]byte{0x01})
-	} else {
-		data.Write([]byte{0x00})
-	}
+func (s *Sphero) ReadLocator(res chan<- *Response, mode ...SendMode) error {
+	return NotImplementedError
+}
 
-	return s.Send(DID_SPHERO, CMD_SET_RGB_LED, data.Bytes(), res)
+func (s *Sphero) SetRGBLEDOutput(red, green, blue uint8, flag bool, res chan<- *Response, mode ...SendMode) error {
+	return s.Send(DID_SPHERO, CMD_SET_RGB_LED, rgbLEDData(red, green, blue, flag), res, mode...)
 }
 
-func (s *Sphero) SetBackLEDOutput(brightness uint8, res chan<- *Response) error {
-	var data bytes.Buffer
-	binary.Write(&data, binary.BigEndian, brightness)
-	return s.Send(DID_SPHERO, CMD_SET_BACK_LED, data.Bytes(), res)
+func rgbLEDData(red, green, blue uint8, flag bool) []byte {
+	// The flag sets the "user LED color".
+	return encode(DID_SPHERO, CMD_SET_RGB_LED, red, green, blue, flag)
 }
 
-// Returns the "user LED color". The color displayed after a successful bluetooth connection.
-func (s *Sphero) GetRGBLED(res chan<- *Response) error {
-	return s.Send(DID_SPHERO, CMD_GET_RGB_LED, nil, res)
+func (s *Sphero) SetBackLEDOutput(brightness uint8, res chan<- *Response, mode ...SendMode) error {
+	return s.Send(DID_SPHERO, CMD_SET_BACK_LED, backLEDData(brightness), res, mode...)
 }
 
-func (s *Sphero) Roll() error {
-	return NotImplementedError
+func backLEDData(brightness uint8) []byte {
+	return encode(DID_SPHERO, CMD_SET_BACK_LED, brightness)
 }
 
-func (s *Sphero) SetRawMotorValues() error {
-	return NotImplementedError
+// Returns the "user LED color". The color displayed after a successful bluetooth connection.
+func (s *Sphero) GetRGBLED(res chan<- *Response, mode ...SendMode) error {
+	return s.Send(DID_SPHERO, CMD_GET_RGB_LED, nil, res, mode...)
 }
 
 // Gets the current power state of the device. See `Response.PowerState()`.
-func (s *Sphero) GetPowerState(res chan<- *Response) error {
-	return s.Send(DID_CORE, CMD_GET_PWR_STATE, nil, res)
+func (s *Sphero) GetPowerState(res chan<- *Response, mode ...SendMode) error {
+	return s.Send(DID_CORE, CMD_GET_PWR_STATE, nil, res, mode...)
 }
 
 // Turns on async power notifications.
-func (s *Sphero) SetPowerNotification(flag bool, res chan<- *Response) error {
-	data := make([]byte, 1)
-	if flag {
-		data[0] = 0x01
-	}
-	return s.Send(DID_SPHERO, CMD_SET_PWR_NOTIFY, data, res)
+func (s *Sphero) SetPowerNotification(flag bool, res chan<- *Response, mode ...SendMode) error {
+	return s.Send(DID_CORE, CMD_SET_PWR_NOTIFY, powerNotificationData(flag), res, mode...)
+}
+
+func powerNotificationData(flag bool) []byte {
+	return encode(DID_CORE, CMD_SET_PWR_NOTIFY, flag)
 }
@@ -1,5 +1,18 @@
 package sphero
 
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/FreeFlow/sphero/codec"
+)
+
 func ExampleAsyncResponse_Sensors() {
 	r := &AsyncResponse{}
 
@@ -20,3 +33,436 @@ func ExampleAsyncResponse_Sensors() {
 		// Handle error
 	}
 }
+
+func TestNewSpheroConn(t *testing.T) {
+	client, device := net.Pipe()
+	s := NewSpheroConn(client, nil)
+	defer s.Close()
+
+	if _, ok := s.conn.(Deadliner); !ok {
+		t.Fatal("net.Conn should satisfy Deadliner")
+	}
+
+	ch := make(chan *Response, 1)
+	go s.Ping(ch)
+
+	cmd := make([]byte, 7)
+	if _, err := io.ReadFull(device, cmd); err != nil {
+		t.Fatal(err)
+	}
+	if cmd[2] != DID_CORE || cmd[3] != CMD_PING {
+		t.Fatalf("Expected ping command but got %#x", cmd)
+	}
+
+	answer := []byte{SOP1, SOP2_ANSWER, ORBOTIX_RSP_CODE_OK, cmd[4], 0x01, 0x00}
+	answer[5] = codec.Checksum(answer[2:5])
+	if _, err := device.Write(answer); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-ch:
+		if r.Seq != cmd[4] || r.Error() != nil {
+			t.Fatalf("Unexpected response %#v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for ping response")
+	}
+}
+
+func TestDoReleasesSlot(t *testing.T) {
+	client, device := net.Pipe()
+	defer device.Close()
+	go io.Copy(io.Discard, device) // Never answers
+
+	s := NewSpheroConn(client, nil)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.Do(ctx, DID_CORE, CMD_PING, nil); err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded but got %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.res) != 0 {
+		t.Fatalf("Expected no pending responses but got %d", len(s.res))
+	}
+}
+
+func TestSeqSkipsPendingSlots(t *testing.T) {
+	client, device := net.Pipe()
+	defer device.Close()
+	go io.Copy(io.Discard, device) // Never answers
+
+	s := NewSpheroConn(client, nil)
+	defer s.Close()
+
+	// Wrap around to a seq that's still waiting for an answer.
+	ch := make(chan *Response, 1)
+	s.mu.Lock()
+	s.seq = 0xff
+	s.res[0x00] = &pending{ch: ch}
+	s.res[0x01] = &pending{ch: ch}
+	s.mu.Unlock()
+
+	seq, err := s.send(DID_CORE, CMD_PING, nil, nil, ModeAnswer, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 0x02 {
+		t.Fatalf("Expected seq 0x02 but got %#x", seq)
+	}
+
+	// Fill every slot.
+	s.mu.Lock()
+	for i := 0; i < 256; i++ {
+		s.res[uint8(i)] = &pending{ch: ch}
+	}
+	s.mu.Unlock()
+
+	if _, err := s.send(DID_CORE, CMD_PING, nil, nil, ModeAnswer, time.Time{}); err != SequenceExhaustedError {
+		t.Fatalf("Expected SequenceExhaustedError but got %v", err)
+	}
+}
+
+// Answers every command with ORBOTIX_RSP_CODE_OK.
+func answerAll(device net.Conn) {
+	header := make([]byte, 6)
+	for {
+		if _, err := io.ReadFull(device, header); err != nil {
+			return
+		}
+		if _, err := io.CopyN(io.Discard, device, int64(header[5])); err != nil {
+			return
+		}
+		answer := []byte{SOP1, SOP2_ANSWER, ORBOTIX_RSP_CODE_OK, header[4], 0x01, 0x00}
+		answer[5] = codec.Checksum(answer[2:5])
+		if _, err := device.Write(answer); err != nil {
+			return
+		}
+	}
+}
+
+func TestSlotsAreSingleUse(t *testing.T) {
+	client, device := net.Pipe()
+	defer device.Close()
+	go answerAll(device)
+
+	s := NewSpheroConn(client, nil)
+	defer s.Close()
+
+	// Many more commands than seq numbers, each answer must arrive on the
+	// channel of the command it answers.
+	for i := 0; i < 1000; i++ {
+		ch := make(chan *Response, 1)
+		seq, err := s.send(DID_CORE, CMD_PING, nil, ch, ModeAnswer, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case r := <-ch:
+			if r.Seq != seq {
+				t.Fatalf("Expected an answer to %#x but got %#x", seq, r.Seq)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for response")
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.res) != 0 {
+		t.Fatalf("Expected no pending responses but got %d", len(s.res))
+	}
+}
+
+func TestResponseTimeout(t *testing.T) {
+	client, device := net.Pipe()
+	defer device.Close()
+	go io.Copy(io.Discard, device) // Never answers
+
+	s := NewSpheroConn(client, nil)
+	defer s.Close()
+	s.SetResponseTimeout(10 * time.Millisecond)
+
+	// Unbuffered and not received from until after the timeout.
+	ch := make(chan *Response)
+	s.Ping(ch)
+	time.Sleep(50 * time.Millisecond)
+
+	select {
+	case r := <-ch:
+		if r.Error() != ResponseTimeoutError {
+			t.Fatalf("Expected ResponseTimeoutError but got %v", r.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Request never timed out")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.res) != 0 {
+		t.Fatalf("Expected no pending responses but got %d", len(s.res))
+	}
+}
+
+func TestCloseFailsPending(t *testing.T) {
+	client, device := net.Pipe()
+	defer device.Close()
+	go io.Copy(io.Discard, device) // Never answers
+
+	s := NewSpheroConn(client, nil)
+
+	ch := make(chan *Response) // Not received from until after Close
+	s.Ping(ch)
+	s.Close()
+
+	select {
+	case r := <-ch:
+		if r.Error() != ClosedError {
+			t.Fatalf("Expected ClosedError but got %v", r.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ClosedError was never delivered")
+	}
+}
+
+func TestUndrainedResponsesReleased(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	client, device := net.Pipe()
+	defer device.Close()
+	go func() {
+		// Answers the first ping, the rest time out.
+		dec := codec.NewCommandDecoder(device)
+		p, err := dec.Decode()
+		if err != nil {
+			return
+		}
+		codec.NewEncoder(device).Encode(&codec.AnswerPacket{Seq: p.(*codec.CommandPacket).Seq})
+		io.Copy(io.Discard, device)
+	}()
+
+	s := NewSpheroConn(client, nil)
+	s.SetResponseTimeout(10 * time.Millisecond)
+
+	ch := make(chan *Response) // Never received from
+	for i := 0; i < 100; i++ {
+		if err := s.Ping(ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	s.Close()
+	device.Close()
+
+	deadline := time.Now().Add(deliveryGrace + time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left running", runtime.NumGoroutine()-before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSendModes(t *testing.T) {
+	client, device := net.Pipe()
+	defer device.Close()
+
+	s := NewSpheroConn(client, nil)
+	defer s.Close()
+
+	for _, mode := range []SendMode{ModeNoAnswer, ModeNoAnswerNoReset, ModeAnswerNoReset} {
+		ch := make(chan *Response, 1)
+		errc := make(chan error, 1)
+		go func() { errc <- s.SetBackLEDOutput(0x80, ch, mode) }()
+
+		frame := make([]byte, 8)
+		if _, err := io.ReadFull(device, frame); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+		if frame[1] != byte(mode) {
+			t.Fatalf("%v: expected SOP2 %#x but got %#x", mode, byte(mode), frame[1])
+		}
+		if frame[7] != codec.Checksum(frame[2:7]) {
+			t.Fatalf("%v: bad checksum in % x", mode, frame)
+		}
+
+		s.mu.Lock()
+		n := len(s.res)
+		s.mu.Unlock()
+		switch {
+		case mode.Answer() && n != 1:
+			t.Fatalf("%v: expected a response slot", mode)
+		case !mode.Answer() && (n != 0 || frame[4] != 0):
+			t.Fatalf("%v: expected no response slot and seq 0 but got %d slots and seq %#x", mode, n, frame[4])
+		}
+	}
+}
+
+func TestInvalidSendMode(t *testing.T) {
+	client, device := net.Pipe()
+	defer device.Close()
+
+	s := NewSpheroConn(client, nil)
+	defer s.Close()
+
+	for _, mode := range []SendMode{0x00, 0x01, 0xfb, 0x7f} {
+		if err := s.Ping(nil, mode); err != InvalidSendModeError {
+			t.Fatalf("%v: expected InvalidSendModeError but got %v", mode, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.res) != 0 || s.seq != 0 {
+		t.Fatalf("Expected no response slots or seq numbers used but got %d slots and seq %d", len(s.res), s.seq)
+	}
+}
+
+func TestCommandFrames(t *testing.T) {
+	client, device := net.Pipe()
+	defer device.Close()
+
+	s := NewSpheroConn(client, nil)
+	defer s.Close()
+	dec := codec.NewCommandDecoder(device)
+
+	// Sends a command and checks the frame decodes to what was meant.
+	check := func(send func() error, did, cid uint8, want []byte) bool {
+		errc := make(chan error, 1)
+		go func() { errc <- send() }()
+		p, err := dec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+		c := p.(*codec.CommandPacket)
+		if c.Sop2 != byte(ModeNoAnswer) || c.Did != did || c.Cid != cid || !bytes.Equal(c.Data, want) {
+			t.Errorf("Expected %s % x but got %s % x", CommandName(did, cid), want, CommandName(c.Did, c.Cid), c.Data)
+			return false
+		}
+		return true
+	}
+	b := func(flag bool) byte {
+		if flag {
+			return 0x01
+		}
+		return 0x00
+	}
+
+	props := map[string]interface{}{
+		"Ping": func() bool {
+			return check(func() error { return s.Ping(nil, ModeNoAnswer) }, DID_CORE, CMD_PING, nil)
+		},
+		"Sleep": func(wakeup uint16, macro uint8, orbBasic uint16) bool {
+			send := func() error { return s.Sleep(time.Duration(wakeup), macro, orbBasic, nil, ModeNoAnswer) }
+			return check(send, DID_CORE, CMD_SLEEP, []byte{byte(wakeup >> 8), byte(wakeup), macro, byte(orbBasic >> 8), byte(orbBasic)})
+		},
+		"SetHeading": func(heading uint16) bool {
+			heading %= 360
+			send := func() error { return s.SetHeading(int16(heading), nil, ModeNoAnswer) }
+			return check(send, DID_SPHERO, CMD_SET_CAL, []byte{byte(heading >> 8), byte(heading)})
+		},
+		"SetStabilization": func(flag bool) bool {
+			send := func() error { return s.SetStabilization(flag, nil, ModeNoAnswer) }
+			return check(send, DID_SPHERO, CMD_SET_STABILIZ, []byte{b(flag)})
+		},
+		"SetDataStreaming": func(n, m int16, pcnt uint8, mask, mask2 uint32) bool {
+			send := func() error {
+				return s.SetDataStreaming(n, m, pcnt, []uint32{mask}, []uint32{mask2}, nil, ModeNoAnswer)
+			}
+			return check(send, DID_SPHERO, CMD_SET_DATA_STREAMING, []byte{
+				byte(n >> 8), byte(n), byte(m >> 8), byte(m),
+				byte(mask >> 24), byte(mask >> 16), byte(mask >> 8), byte(mask),
+				pcnt,
+				byte(mask2 >> 24), byte(mask2 >> 16), byte(mask2 >> 8), byte(mask2),
+			})
+		},
+		"ConfigureCollisionDetection": func(method, xt, yt, xs, ys, dead uint8) bool {
+			send := func() error { return s.ConfigureCollisionDetection(method, xt, yt, xs, ys, dead, nil, ModeNoAnswer) }
+			return check(send, DID_SPHERO, CMD_SET_COLLISION_DET, []byte{method, xt, xs, yt, ys, dead})
+		},
+		"SetRGBLEDOutput": func(r, g, bl uint8, flag bool) bool {
+			send := func() error { return s.SetRGBLEDOutput(r, g, bl, flag, nil, ModeNoAnswer) }
+			return check(send, DID_SPHERO, CMD_SET_RGB_LED, []byte{r, g, bl, b(flag)})
+		},
+		"SetBackLEDOutput": func(brightness uint8) bool {
+			send := func() error { return s.SetBackLEDOutput(brightness, nil, ModeNoAnswer) }
+			return check(send, DID_SPHERO, CMD_SET_BACK_LED, []byte{brightness})
+		},
+		"GetVersion": func() bool {
+			return check(func() error { return s.GetVersion(nil, ModeNoAnswer) }, DID_CORE, CMD_VERSION, nil)
+		},
+		"Roll": func(speed uint8, heading uint16, state uint8) bool {
+			heading, state = heading%360, state%3
+			send := func() error { return s.Roll(speed, heading, RollState(state), nil, ModeNoAnswer) }
+			return check(send, DID_SPHERO, CMD_ROLL, []byte{speed, byte(heading >> 8), byte(heading), state})
+		},
+		"Stop": func(heading uint16) bool {
+			heading %= 360
+			s.mu.Lock()
+			s.heading = heading
+			s.mu.Unlock()
+			send := func() error { return s.Stop(nil, ModeNoAnswer) }
+			return check(send, DID_SPHERO, CMD_ROLL, []byte{0x00, byte(heading >> 8), byte(heading), 0x00})
+		},
+		"SetRawMotorValues": func(lm, lp, rm, rp uint8) bool {
+			lm, rm = lm%5, rm%5
+			send := func() error {
+				return s.SetRawMotorValues(MotorMode(lm), lp, MotorMode(rm), rp, nil, ModeNoAnswer)
+			}
+			return check(send, DID_SPHERO, CMD_SET_RAW_MOTORS, []byte{lm, lp, rm, rp})
+		},
+		"SetRotationRate": func(rate uint8) bool {
+			rate = rate%0xfe + 1
+			send := func() error { return s.SetRotationRate(float64(rate)*RotationRateUnit, nil, ModeNoAnswer) }
+			return check(send, DID_SPHERO, CMD_SET_ROTATION_RATE, []byte{rate})
+		},
+		"Boost": func(units uint8, heading uint16) bool {
+			units, heading = units%0xff+1, heading%360
+			send := func() error { return s.Boost(time.Duration(units)*BoostUnit, heading, nil, ModeNoAnswer) }
+			return check(send, DID_SPHERO, CMD_BOOST, []byte{units, byte(heading >> 8), byte(heading)})
+		},
+		"SelfLevel": func(finalAngle, sleep, control bool, angle, timeout, trueTime uint8) bool {
+			angle %= 91
+			opts := SelfLevelOptions{
+				FinalAngle:    finalAngle,
+				Sleep:         sleep,
+				ControlSystem: control,
+				AngleLimit:    angle,
+				Timeout:       time.Duration(timeout) * time.Second,
+				TrueTime:      time.Duration(trueTime) * 10 * time.Millisecond,
+			}
+			options := SELF_LEVEL_START | b(finalAngle)<<1 | b(sleep)<<2 | b(control)<<3
+			send := func() error { return s.SelfLevel(opts, nil, ModeNoAnswer) }
+			return check(send, DID_SPHERO, CMD_SELF_LEVEL, []byte{options, angle, timeout, trueTime})
+		},
+		"AbortSelfLevel": func() bool {
+			send := func() error { return s.AbortSelfLevel(nil, ModeNoAnswer) }
+			return check(send, DID_SPHERO, CMD_SELF_LEVEL, []byte{0x00, 0x00, 0x00, 0x00})
+		},
+		"GetRGBLED": func() bool {
+			return check(func() error { return s.GetRGBLED(nil, ModeNoAnswer) }, DID_SPHERO, CMD_GET_RGB_LED, nil)
+		},
+		"GetPowerState": func() bool {
+			return check(func() error { return s.GetPowerState(nil, ModeNoAnswer) }, DID_CORE, CMD_GET_PWR_STATE, nil)
+		},
+		"SetPowerNotification": func(flag bool) bool {
+			send := func() error { return s.SetPowerNotification(flag, nil, ModeNoAnswer) }
+			return check(send, DID_CORE, CMD_SET_PWR_NOTIFY, []byte{b(flag)})
+		},
+	}
+	for name, prop := range props {
+		if err := quick.Check(prop, &quick.Config{MaxCount: 50}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,573 @@
+// Package spherosim provides an in-process virtual Sphero. It implements the
+// device side of the protocol spoken by package sphero, so a Sphero can be
+// driven without a paired robot:
+//
+//	sim := spherosim.New()
+//	defer sim.Close()
+//	s := sphero.NewSpheroConn(sim.Conn(), async)
+package spherosim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/FreeFlow/sphero"
+	"github.com/FreeFlow/sphero/codec"
+)
+
+// Maximum sensor sampling rate in hz. SetDataStreaming divides this by N.
+const maxSampleRate = 400
+
+// SOP2 bits set by the client. A command only gets an answer if the answer
+// bit is set.
+const sop2AnswerBit = 0x01
+
+// State is a snapshot of the simulated device state.
+type State struct {
+	Color         sphero.Color // Current LED color
+	UserColor     sphero.Color // "User LED color", returned by GetRGBLED
+	BackLED       uint8        // Tail light brightness
+	Stabilization bool
+	Heading       uint16 // Heading of the last Roll
+	Speed         uint8  // Speed of the last Roll
+	RollState     sphero.RollState
+	Motors        Motors // Set by SetRawMotorValues
+	Calibration   uint16 // Heading adjustment of the last SetHeading
+	RotationRate  uint8  // In units of sphero.RotationRateUnit
+	Boost         Boost  // Last boost
+	SelfLevel     SelfLevel
+	PowerNotify   bool
+	Power         sphero.PowerState
+	Streaming     Streaming
+	Collision     Collision
+	Asleep        bool
+}
+
+// Streaming is the data streaming configuration set by SetDataStreaming.
+type Streaming struct {
+	N, M        uint16
+	Mask, Mask2 uint32
+	Count       uint8 // Packets remaining, 0 is unlimited
+}
+
+// SelfLevel is the request of the last SelfLevel or AbortSelfLevel.
+type SelfLevel struct {
+	Options, AngleLimit, Timeout, TrueTime uint8
+}
+
+// Boost is a burst of speed requested with sphero.Boost.
+type Boost struct {
+	Duration time.Duration
+	Heading  uint16
+}
+
+// Motors is the raw motor state set by SetRawMotorValues.
+type Motors struct {
+	LeftMode, RightMode   sphero.MotorMode
+	LeftPower, RightPower uint8
+}
+
+// Collision is the configuration set by ConfigureCollisionDetection.
+type Collision struct {
+	Method             uint8
+	XThreshold, XSpeed uint8
+	YThreshold, YSpeed uint8
+	DeadTime           uint8
+}
+
+// Sim is a virtual Sphero. A Sim keeps its state across connections, just
+// as a robot keeps its state when the Bluetooth link drops.
+type Sim struct {
+	mu      sync.Mutex
+	state   State
+	sensors map[uint64]int16
+	conn    *Conn
+	stream  chan struct{} // Closed to stop the current streaming goroutine
+	closed  bool
+
+	selfLevel     sphero.SelfLevelResult // Outcome of self leveling
+	selfLevelHang bool                   // Self leveling never ends unless aborted
+}
+
+// New creates a virtual Sphero with a full battery and stabilization on.
+func New() *Sim {
+	return &Sim{
+		state:     initialState(),
+		sensors:   make(map[uint64]int16),
+		selfLevel: sphero.SelfLevelSuccess,
+	}
+}
+
+func initialState() State {
+	return State{
+		Stabilization: true,
+		Power: sphero.PowerState{
+			RecVer:      0x01,
+			PowerState:  sphero.BATTERY_OK,
+			BattVoltage: 751,
+		},
+	}
+}
+
+// Reset simulates the robot rebooting: the current connection drops and all
+// configuration returns to its power-on defaults.
+func (sim *Sim) Reset() {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	sim.stopStreaming()
+	sim.state = initialState()
+	if sim.conn != nil {
+		sim.conn.close()
+		sim.conn = nil
+	}
+}
+
+// Conn opens a new connection to the virtual Sphero. Any previous connection
+// is dropped, its reads and writes fail with io.ErrClosedPipe.
+func (sim *Sim) Conn() *Conn {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	if sim.conn != nil {
+		sim.conn.close()
+	}
+	c := &Conn{sim: sim, closed: sim.closed}
+	c.cond = sync.NewCond(&sim.mu)
+	if !sim.closed {
+		sim.conn = c
+	}
+	return c
+}
+
+// Drop simulates losing the link: the current connection fails as if the
+// robot went out of range.
+func (sim *Sim) Drop() {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	if sim.conn != nil {
+		sim.conn.close()
+		sim.conn = nil
+	}
+}
+
+// Close shuts down the virtual Sphero and its current connection.
+func (sim *Sim) Close() error {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	sim.closed = true
+	sim.stopStreaming()
+	if sim.conn != nil {
+		sim.conn.close()
+		sim.conn = nil
+	}
+	return nil
+}
+
+// State returns a snapshot of the device state.
+func (sim *Sim) State() State {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.state
+}
+
+// SetSensor sets the value reported for every sensor selected by `mask` and
+// `mask2` (see the data streaming masks in package sphero).
+func (sim *Sim) SetSensor(mask, mask2 uint32, value int16) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	bits := uint64(mask)<<32 | uint64(mask2)
+	for i := uint(0); i < 64; i++ {
+		if bit := uint64(1) << i; bits&bit != 0 {
+			sim.sensors[bit] = value
+		}
+	}
+}
+
+// SetPowerState changes the power state and, if power notifications are
+// enabled, emits an ID_POWER_NOTIFICATIONS async packet.
+func (sim *Sim) SetPowerState(ps sphero.PowerState) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	sim.state.Power = ps
+	if sim.state.PowerNotify {
+		sim.async(sphero.ID_POWER_NOTIFICATIONS, []byte{ps.PowerState})
+	}
+}
+
+// SetSelfLevelResult sets the outcome self leveling reports from now on,
+// SelfLevelSuccess by default. With `hang` it never finishes unless aborted.
+func (sim *Sim) SetSelfLevelResult(r sphero.SelfLevelResult, hang bool) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.selfLevel, sim.selfLevelHang = r, hang
+}
+
+// Collide emits an ID_COLLISION_DETECTED async packet if collision detection
+// is enabled. Reports whether the collision was detected.
+func (sim *Sim) Collide(c sphero.Collision) bool {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	if sim.state.Collision.Method == 0 {
+		return false
+	}
+	var data bytes.Buffer
+	binary.Write(&data, binary.BigEndian, c)
+	sim.async(sphero.ID_COLLISION_DETECTED, data.Bytes())
+	return true
+}
+
+// Parses as many complete command frames as `c.in` holds and answers them.
+// Called with sim.mu held.
+func (sim *Sim) process(c *Conn) {
+	for {
+		p, n, err := codec.ParseCommand(c.in)
+		if n == 0 && err == nil {
+			return
+		}
+		c.in = c.in[n:]
+
+		var cmd *codec.CommandPacket
+		var mrsp byte
+		var answer []byte
+
+		var frameErr *codec.FrameError
+		switch {
+		case errors.As(err, &frameErr) && errors.Is(err, codec.InvalidChecksumError):
+			// Answered so the client learns its seq failed.
+			cmd = frameErr.Packet.(*codec.CommandPacket)
+			mrsp = sphero.ORBOTIX_RSP_CODE_ECHKSUM
+		case err != nil:
+			continue // Resync on anything that doesn't look like a command.
+		default:
+			cmd = p.(*codec.CommandPacket)
+			mrsp, answer = sim.command(cmd.Did, cmd.Cid, cmd.Data)
+		}
+
+		if cmd.Sop2&sop2AnswerBit != 0 {
+			c.send(&codec.AnswerPacket{Mrsp: mrsp, Seq: cmd.Seq, Data: answer})
+		}
+	}
+}
+
+// Executes a single command, returning the MRSP code and answer data.
+// Called with sim.mu held.
+func (sim *Sim) command(did, cid uint8, data []byte) (uint8, []byte) {
+	switch did {
+	case sphero.DID_CORE:
+		return sim.coreCommand(cid, data)
+	case sphero.DID_SPHERO:
+		return sim.spheroCommand(cid, data)
+	case sphero.DID_BOOTLOADER:
+		return sphero.ORBOTIX_RSP_CODE_EUNSUPP, nil
+	}
+	return sphero.ORBOTIX_RSP_CODE_EBAD_DID, nil
+}
+
+func (sim *Sim) coreCommand(cid uint8, data []byte) (uint8, []byte) {
+	switch cid {
+	case sphero.CMD_PING:
+		return sphero.ORBOTIX_RSP_CODE_OK, nil
+	case sphero.CMD_VERSION:
+		// RECV, MDL, HW, MSA-ver, MSA-rev, BL, BAS, MACRO
+		return sphero.ORBOTIX_RSP_CODE_OK, []byte{0x01, 0x02, 0x01, 0x01, 0x2a, 0x31, 0x10, 0x02}
+	case sphero.CMD_GET_PWR_STATE:
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.BigEndian, sim.state.Power)
+		return sphero.ORBOTIX_RSP_CODE_OK, buf.Bytes()
+	case sphero.CMD_SET_PWR_NOTIFY:
+		if len(data) != 1 {
+			return sphero.ORBOTIX_RSP_CODE_EPARAM, nil
+		}
+		sim.state.PowerNotify = data[0] != 0
+		return sphero.ORBOTIX_RSP_CODE_OK, nil
+	case sphero.CMD_SLEEP:
+		if len(data) != 5 {
+			return sphero.ORBOTIX_RSP_CODE_EPARAM, nil
+		}
+		sim.stopStreaming()
+		sim.state.Asleep = true
+		return sphero.ORBOTIX_RSP_CODE_OK, nil
+	}
+	return sphero.ORBOTIX_RSP_CODE_EBAD_CMD, nil
+}
+
+func (sim *Sim) spheroCommand(cid uint8, data []byte) (uint8, []byte) {
+	switch cid {
+	case sphero.CMD_SET_CAL:
+		if len(data) != 2 {
+			return sphero.ORBOTIX_RSP_CODE_EPARAM, nil
+		}
+		sim.state.Calibration = binary.BigEndian.Uint16(data)
+	case sphero.CMD_SET_STABILIZ:
+		if len(data) != 1 {
+			return sphero.ORBOTIX_RSP_CODE_EPARAM, nil
+		}
+		sim.state.Stabilization = data[0] != 0
+	case sphero.CMD_SET_ROTATION_RATE:
+		if len(data) != 1 {
+			return sphero.ORBOTIX_RSP_CODE_EPARAM, nil
+		}
+		sim.state.RotationRate = data[0]
+	case sphero.CMD_SET_DATA_STREAMING:
+		if len(data) != 9 && len(data) != 13 {
+			return sphero.ORBOTIX_RSP_CODE_EPARAM, nil
+		}
+		st := Streaming{
+			N:     binary.BigEndian.Uint16(data[0:2]),
+			M:     binary.BigEndian.Uint16(data[2:4]),
+			Mask:  binary.BigEndian.Uint32(data[4:8]),
+			Count: data[8],
+		}
+		if len(data) == 13 {
+			st.Mask2 = binary.BigEndian.Uint32(data[9:13])
+		}
+		if st.N == 0 || st.M == 0 {
+			return sphero.ORBOTIX_RSP_CODE_EPARAM, nil
+		}
+		if frameSize(st)*int(st.M) > codec.MaxAsyncData {
+			return sphero.ORBOTIX_RSP_CODE_EPARAM, nil
+		}
+		sim.state.Streaming = st
+		sim.startStreaming()
+	case sphero.CMD_SET_COLLISION_DET:
+		if len(data) != 6 {
+			return sphero.ORBOTIX_RSP_CODE_EPARAM, nil
+		}
+		sim.state.Collision = Collision{
+			Method:     data[0],
+			XThreshold: data[1],
+			XSpeed:     data[2],
+			YThreshold: data[3],
+			YSpeed:     data[4],
+			DeadTime:   data[5],
+		}
+	case sphero.CMD_SET_RGB_LED:
+		if len(data) != 4 {
+			return sphero.ORBOTIX_RSP_CODE_EPARAM, nil
+		}
+		sim.state.Color = sphero.Color{R: data[0], G: data[1], B: data[2]}
+		if data[3] != 0 {
+			sim.state.UserColor = sim.state.Color
+		}
+	case sphero.CMD_SET_BACK_LED:
+		if len(data) != 1 {
+			return sphero.ORBOTIX_RSP_CODE_EPARAM, nil
+		}
+		sim.state.BackLED = data[0]
+	case sphero.CMD_GET_RGB_LED:
+		c := sim.state.UserColor
+		return sphero.ORBOTIX_RSP_CODE_OK, []byte{c.R, c.G, c.B}
+	case sphero.CMD_SELF_LEVEL:
+		if len(data) != 4 {
+			return sphero.ORBOTIX_RSP_CODE_EPARAM, nil
+		}
+		sim.state.SelfLevel = SelfLevel{data[0], data[1], data[2], data[3]}
+		switch {
+		case data[0]&sphero.SELF_LEVEL_START == 0:
+			sim.async(sphero.ID_SELF_LEVEL_RESULT, []byte{uint8(sphero.SelfLevelAborted)})
+		case !sim.selfLevelHang:
+			sim.async(sphero.ID_SELF_LEVEL_RESULT, []byte{uint8(sim.selfLevel)})
+		}
+	case sphero.CMD_BOOST:
+		if len(data) != 3 || data[0] == 0 || binary.BigEndian.Uint16(data[1:]) > 359 {
+			return sphero.ORBOTIX_RSP_CODE_EPARAM, nil
+		}
+		sim.state.Boost = Boost{time.Duration(data[0]) * sphero.BoostUnit, binary.BigEndian.Uint16(data[1:])}
+	case sphero.CMD_SET_RAW_MOTORS:
+		if len(data) != 4 || data[0] > 4 || data[2] > 4 {
+			return sphero.ORBOTIX_RSP_CODE_EPARAM, nil
+		}
+		m := &sim.state.Motors
+		if sphero.MotorMode(data[0]) != sphero.MotorIgnore {
+			m.LeftMode, m.LeftPower = sphero.MotorMode(data[0]), data[1]
+		}
+		if sphero.MotorMode(data[2]) != sphero.MotorIgnore {
+			m.RightMode, m.RightPower = sphero.MotorMode(data[2]), data[3]
+		}
+		sim.state.Stabilization = false
+	case sphero.CMD_ROLL:
+		if len(data) != 4 || binary.BigEndian.Uint16(data[1:3]) > 359 || data[3] > 2 {
+			return sphero.ORBOTIX_RSP_CODE_EPARAM, nil
+		}
+		sim.state.Speed = data[0]
+		sim.state.Heading = binary.BigEndian.Uint16(data[1:3])
+		sim.state.RollState = sphero.RollState(data[3])
+	default:
+		return sphero.ORBOTIX_RSP_CODE_EBAD_CMD, nil
+	}
+	return sphero.ORBOTIX_RSP_CODE_OK, nil
+}
+
+// Starts emitting ID_SENSOR_DATA_STREAMING packets for the current streaming
+// configuration, replacing any previous stream. Called with sim.mu held.
+func (sim *Sim) startStreaming() {
+	sim.stopStreaming()
+
+	st := sim.state.Streaming
+	if st.Mask == 0 && st.Mask2 == 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	sim.stream = stop
+
+	// Each packet carries M frames sampled at 400hz / N.
+	period := time.Second * time.Duration(st.N) * time.Duration(st.M) / maxSampleRate
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			sim.mu.Lock()
+			if sim.stream != stop {
+				sim.mu.Unlock()
+				return
+			}
+			sim.async(sphero.ID_SENSOR_DATA_STREAMING, sim.frames())
+			if sim.state.Streaming.Count > 0 {
+				sim.state.Streaming.Count--
+				if sim.state.Streaming.Count == 0 {
+					sim.stopStreaming()
+				}
+			}
+			sim.mu.Unlock()
+		}
+	}()
+}
+
+// Called with sim.mu held.
+func (sim *Sim) stopStreaming() {
+	if sim.stream != nil {
+		close(sim.stream)
+		sim.stream = nil
+	}
+}
+
+// Size in bytes of a single sample frame.
+func frameSize(st Streaming) int {
+	n := 0
+	for bits := uint64(st.Mask)<<32 | uint64(st.Mask2); bits != 0; bits &= bits - 1 {
+		n += 2
+	}
+	return n
+}
+
+// Builds M sample frames for the current masks. Each frame holds one int16
+// per selected sensor, most significant mask bit first. Called with sim.mu
+// held.
+func (sim *Sim) frames() []byte {
+	st := sim.state.Streaming
+	bits := uint64(st.Mask)<<32 | uint64(st.Mask2)
+
+	var frame bytes.Buffer
+	for i := 63; i >= 0; i-- {
+		if bit := uint64(1) << uint(i); bits&bit != 0 {
+			binary.Write(&frame, binary.BigEndian, sim.sensors[bit])
+		}
+	}
+	return bytes.Repeat(frame.Bytes(), int(st.M))
+}
+
+// Queues an async packet on the current connection, if any. Called with
+// sim.mu held.
+func (sim *Sim) async(id uint8, data []byte) {
+	if sim.conn != nil {
+		sim.conn.send(&codec.AsyncPacket{IdCode: id, Data: data})
+	}
+}
+
+// Conn is a connection to a virtual Sphero. It implements sphero.Transport.
+type Conn struct {
+	sim    *Sim
+	cond   *sync.Cond // Signaled when `out` grows or the connection closes
+	in     []byte     // Bytes written by the client, not yet parsed
+	out    []byte     // Bytes queued for the client to read
+	closed bool
+}
+
+// Name implements sphero.Transport.
+func (c *Conn) Name() string {
+	return "spherosim"
+}
+
+// Read blocks until the virtual Sphero has sent data or the connection is
+// closed.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.sim.mu.Lock()
+	defer c.sim.mu.Unlock()
+
+	for len(c.out) == 0 && !c.closed {
+		c.cond.Wait()
+	}
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n := copy(p, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+// Write delivers command bytes to the virtual Sphero. Commands are executed,
+// and answered, as soon as a complete frame has been written.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.sim.mu.Lock()
+	defer c.sim.mu.Unlock()
+
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	c.in = append(c.in, p...)
+	c.sim.process(c)
+	return len(p), nil
+}
+
+// Close closes the connection. The virtual Sphero keeps its state.
+func (c *Conn) Close() error {
+	c.sim.mu.Lock()
+	defer c.sim.mu.Unlock()
+
+	c.close()
+	if c.sim.conn == c {
+		c.sim.conn = nil
+	}
+	return nil
+}
+
+// Called with sim.mu held.
+func (c *Conn) close() {
+	c.closed = true
+	c.cond.Broadcast()
+}
+
+// Called with sim.mu held.
+func (c *Conn) queue(b []byte) {
+	if c.closed {
+		return
+	}
+	c.out = append(c.out, b...)
+	c.cond.Broadcast()
+}
+
+// Queues a packet for the client. Called with sim.mu held.
+func (c *Conn) send(p codec.Packet) {
+	buf, err := p.MarshalBinary()
+	if err != nil {
+		panic(err) // The simulator only builds packets that fit
+	}
+	c.queue(buf)
+}
@@ -0,0 +1,241 @@
+package spherosim_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/FreeFlow/sphero"
+	"github.com/FreeFlow/sphero/spherosim"
+)
+
+func connect(t *testing.T) (*spherosim.Sim, *sphero.Sphero, chan *sphero.AsyncResponse) {
+	sim := spherosim.New()
+	async := make(chan *sphero.AsyncResponse, 16)
+	s := sphero.NewSpheroConn(sim.Conn(), async)
+	t.Cleanup(func() {
+		s.Close()
+		sim.Close()
+	})
+	return sim, s, async
+}
+
+func receive(t *testing.T, ch <-chan *sphero.Response) *sphero.Response {
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for response")
+	}
+	return nil
+}
+
+func receiveAsync(t *testing.T, ch <-chan *sphero.AsyncResponse) *sphero.AsyncResponse {
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for async response")
+	}
+	return nil
+}
+
+func TestRGBLED(t *testing.T) {
+	sim, s, _ := connect(t)
+	ch := make(chan *sphero.Response, 1)
+
+	s.SetRGBLEDOutput(0x10, 0x20, 0x30, true, ch)
+	if err := receive(t, ch).Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.GetRGBLED(ch)
+	c, err := receive(t, ch).Color()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *c != (sphero.Color{R: 0x10, G: 0x20, B: 0x30}) {
+		t.Fatalf("Unexpected color %#v", c)
+	}
+	if st := sim.State(); st.UserColor != *c || st.Color != *c {
+		t.Fatalf("Unexpected state %#v", st)
+	}
+}
+
+func TestPowerState(t *testing.T) {
+	sim, s, async := connect(t)
+	ch := make(chan *sphero.Response, 1)
+
+	s.GetPowerState(ch)
+	ps, err := receive(t, ch).PowerState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.PowerState != sphero.BATTERY_OK || ps.BattVoltage != 751 {
+		t.Fatalf("Unexpected power state %#v", ps)
+	}
+
+	s.SetPowerNotification(true, ch)
+	if err := receive(t, ch).Error(); err != nil {
+		t.Fatal(err)
+	}
+	sim.SetPowerState(sphero.PowerState{RecVer: 1, PowerState: sphero.BATTERY_LOW})
+
+	r := receiveAsync(t, async)
+	if r.IdCode != sphero.ID_POWER_NOTIFICATIONS || len(r.Data) != 1 || r.Data[0] != sphero.BATTERY_LOW {
+		t.Fatalf("Unexpected power notification %#v", r)
+	}
+}
+
+func TestDataStreaming(t *testing.T) {
+	sim, s, async := connect(t)
+	ch := make(chan *sphero.Response, 1)
+
+	sim.SetSensor(sphero.ACCEL_AXIS_X_RAW, 0, 100)
+	sim.SetSensor(0, sphero.VELOCITY_Y, -5)
+
+	// 400hz / 4, 2 frames per packet, 2 packets
+	s.SetDataStreaming(4, 2, 2, []uint32{sphero.ACCEL_RAW}, []uint32{sphero.VELOCITY}, ch)
+	if err := receive(t, ch).Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	var frames [2]struct {
+		AccelX, AccelY, AccelZ int16
+		VelocityX, VelocityY   int16
+	}
+	for i := 0; i < 2; i++ {
+		r := receiveAsync(t, async)
+		if r.IdCode != sphero.ID_SENSOR_DATA_STREAMING {
+			t.Fatalf("Unexpected async response %#v", r)
+		}
+		if err := r.Sensors(&frames); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range frames {
+			if f.AccelX != 100 || f.AccelY != 0 || f.VelocityY != -5 {
+				t.Fatalf("Unexpected sensor frame %#v", f)
+			}
+		}
+	}
+
+	select {
+	case r := <-async:
+		t.Fatalf("Expected streaming to stop after 2 packets but got %#v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDataStreamingLargePackets(t *testing.T) {
+	sim, s, async := connect(t)
+	ch := make(chan *sphero.Response, 1)
+
+	// A distinct value per sensor, so misplaced bytes show up.
+	for i := uint(0); i < 32; i++ {
+		sim.SetSensor(1<<i, 0, int16(32+i))
+		sim.SetSensor(0, 1<<i, int16(i))
+	}
+
+	// Every sensor at 400hz, 200 frames per packet: 25600 bytes per packet.
+	const sensors, m = 64, 200
+	s.SetDataStreaming(1, m, 2, []uint32{0xffffffff}, []uint32{0xffffffff}, ch)
+	if err := receive(t, ch).Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := make([][sensors]int16, m)
+	for i := 0; i < 2; i++ {
+		var r *sphero.AsyncResponse
+		select {
+		case r = <-async:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for async response")
+		}
+		if r.IdCode != sphero.ID_SENSOR_DATA_STREAMING || int(r.Dlen) != sensors*m*2+1 {
+			t.Fatalf("Unexpected async response %#x, %d bytes", r.IdCode, r.Dlen)
+		}
+		if err := r.Sensors(frames); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range frames {
+			for j, v := range f {
+				// Most significant mask bit first.
+				if want := int16(sensors - 1 - j); v != want {
+					t.Fatalf("Expected sensor %d to be %d but got %d", j, want, v)
+				}
+			}
+		}
+	}
+}
+
+func TestCollision(t *testing.T) {
+	sim, s, async := connect(t)
+	ch := make(chan *sphero.Response, 1)
+
+	if sim.Collide(sphero.Collision{}) {
+		t.Fatal("Collision detected while detection is disabled")
+	}
+
+	s.ConfigureCollisionDetection(0x01, 40, 40, 50, 50, 10, ch)
+	if err := receive(t, ch).Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := sphero.Collision{X: 10, Y: -20, Axis: 0x01, XMag: 300, Speed: 80, TimeStamp: 1234}
+	sim.Collide(want)
+
+	c, err := receiveAsync(t, async).Collision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *c != want {
+		t.Fatalf("Expected %#v but got %#v", want, c)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, s, _ := connect(t)
+	ch := make(chan *sphero.Response, 1)
+
+	s.Send(0x7f, 0x01, nil, ch)
+	if err := receive(t, ch).Error(); err != sphero.UnknownDeviceError {
+		t.Fatalf("Expected UnknownDeviceError but got %v", err)
+	}
+
+	s.Send(sphero.DID_SPHERO, 0x7f, nil, ch)
+	if err := receive(t, ch).Error(); err != sphero.UnknownCommandError {
+		t.Fatalf("Expected UnknownCommandError but got %v", err)
+	}
+
+	s.Send(sphero.DID_SPHERO, sphero.CMD_SET_BACK_LED, []byte{1, 2}, ch)
+	if err := receive(t, ch).Error(); err != sphero.InvalidParametersError {
+		t.Fatalf("Expected InvalidParametersError but got %v", err)
+	}
+}
+
+func TestBadChecksum(t *testing.T) {
+	sim := spherosim.New()
+	defer sim.Close()
+	conn := sim.Conn()
+
+	conn.Write([]byte{sphero.SOP1, sphero.SOP2_ANSWER, sphero.DID_CORE, sphero.CMD_PING, 0x07, 0x01, 0x00})
+
+	buf := make([]byte, 6)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 6 || buf[2] != sphero.ORBOTIX_RSP_CODE_ECHKSUM || buf[3] != 0x07 {
+		t.Fatalf("Expected checksum failure but got %#x", buf[:n])
+	}
+}
+
+func TestDrop(t *testing.T) {
+	sim := spherosim.New()
+	defer sim.Close()
+	conn := sim.Conn()
+
+	sim.Drop()
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Expected read on a dropped connection to fail")
+	}
+}
@@ -0,0 +1,75 @@
+package sphero
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/FreeFlow/sphero/codec"
+)
+
+// Done returns a channel that's closed once the connection has ended, either
+// by Close or because the link failed and could not be restored.
+func (s *Sphero) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns nil while the connection is open. After Done is closed it
+// returns why the connection ended: ClosedError after Close, otherwise the
+// error that broke the link.
+func (s *Sphero) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// SetErrorHandler sets a function to receive errors the connection recovers
+// from, such as framing and checksum errors. It's called from the listener
+// goroutine so it must not block. The errors are also logged, see SetLogger.
+func (s *Sphero) SetErrorHandler(f func(error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onError = f
+}
+
+// FramingStats returns counts of the bytes discarded, checksum failures and
+// frames recovered while resynchronizing with the Sphero's byte stream. It's
+// the Framing field of Stats.
+func (s *Sphero) FramingStats() codec.Stats {
+	return s.Stats().Framing
+}
+
+func (s *Sphero) reportError(err error) {
+	s.mu.Lock()
+	f := s.onError
+	s.mu.Unlock()
+
+	if f != nil {
+		f(err)
+	}
+	s.log(slog.LevelWarn, "sphero: recovered from error", "err", err)
+}
+
+// Ends the connection with `err`: closes the transport and fails pending
+// requests. Returns the error from closing the transport, or ClosedError if
+// the connection had already ended.
+func (s *Sphero) shutdown(err error) error {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return ClosedError
+	}
+	s.err = err
+	close(s.done)
+	time.AfterFunc(deliveryGrace, func() { close(s.expired) })
+	conn := s.conn
+	inFlight := s.res
+	s.res = make(map[uint8]*pending)
+	s.mu.Unlock()
+
+	closeErr := conn.Close()
+	s.failPending(inFlight, err)
+	if err != ClosedError {
+		s.log(slog.LevelError, "sphero: connection failed", "err", err)
+	}
+	return closeErr
+}
@@ -0,0 +1,153 @@
+package sphero_test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/FreeFlow/sphero"
+	"github.com/FreeFlow/sphero/codec"
+	"github.com/FreeFlow/sphero/spherosim"
+)
+
+func TestLinkFailure(t *testing.T) {
+	client, device := net.Pipe()
+	defer device.Close()
+	go io.Copy(io.Discard, device) // Never answers
+
+	s := sphero.NewSpheroConn(client, nil)
+	defer s.Close()
+
+	ch := make(chan *sphero.Response, 1)
+	s.Ping(ch)
+	client.Close() // Pull the plug under the listener
+
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Connection should have ended")
+	}
+	if err := s.Err(); err != io.ErrClosedPipe {
+		t.Fatalf("Expected io.ErrClosedPipe but got %v", err)
+	}
+	if r := <-ch; r.Error() != io.ErrClosedPipe {
+		t.Fatalf("Expected the pending request to fail with io.ErrClosedPipe but got %v", r.Error())
+	}
+	if err := s.Ping(nil); err != io.ErrClosedPipe {
+		t.Fatalf("Expected io.ErrClosedPipe but got %v", err)
+	}
+}
+
+func TestPeerClosed(t *testing.T) {
+	client, device := net.Pipe()
+	s := sphero.NewSpheroConn(client, nil)
+	defer s.Close()
+
+	ch := make(chan *sphero.Response, 1)
+	go io.CopyN(io.Discard, device, 7) // Takes the ping, never answers
+	if err := s.Ping(ch); err != nil {
+		t.Fatal(err)
+	}
+	device.Close() // The Sphero end goes away
+
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Connection should have ended")
+	}
+	if err := s.Err(); err != sphero.ConnectionLostError {
+		t.Fatalf("Expected ConnectionLostError but got %v", err)
+	}
+	if r := <-ch; r.Error() != sphero.ConnectionLostError {
+		t.Fatalf("Expected the pending request to fail with ConnectionLostError but got %v", r.Error())
+	}
+}
+
+func TestClose(t *testing.T) {
+	sim := spherosim.New()
+	defer sim.Close()
+
+	s := sphero.NewSpheroConn(sim.Conn(), nil)
+	if s.Err() != nil {
+		t.Fatalf("Expected no error while open but got %v", s.Err())
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-s.Done()
+	if s.Err() != sphero.ClosedError {
+		t.Fatalf("Expected ClosedError but got %v", s.Err())
+	}
+	if err := s.Close(); err != sphero.ClosedError {
+		t.Fatalf("Expected ClosedError closing twice but got %v", err)
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	client, device := net.Pipe()
+	defer device.Close()
+
+	s := sphero.NewSpheroConn(client, nil)
+	defer s.Close()
+
+	errs := make(chan error, 1)
+	s.SetErrorHandler(func(err error) {
+		errs <- err
+	})
+
+	// An answer with a bad checksum
+	device.Write([]byte{sphero.SOP1, sphero.SOP2_ANSWER, sphero.ORBOTIX_RSP_CODE_OK, 0x01, 0x01, 0x00})
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("Expected a checksum error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Error handler not called")
+	}
+	if s.Err() != nil {
+		t.Fatalf("Checksum errors shouldn't end the connection but got %v", s.Err())
+	}
+}
+
+func TestFramingStats(t *testing.T) {
+	client, device := net.Pipe()
+	defer device.Close()
+
+	s := sphero.NewSpheroConn(client, nil)
+	defer s.Close()
+
+	ch := make(chan *sphero.Response, 1)
+	go func() {
+		ping := make([]byte, 7)
+		io.ReadFull(device, ping)
+
+		answer, _ := (&codec.AnswerPacket{Seq: ping[4]}).MarshalBinary()
+		corrupt := append([]byte(nil), answer...)
+		corrupt[len(corrupt)-1]++
+		noise := []byte{0x00, 0x13, sphero.SOP1, 0x37}
+
+		device.Write(append(append(noise, corrupt...), answer...))
+	}()
+	if err := s.Ping(ch); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-ch:
+		if err := r.Error(); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected to recover the answer")
+	}
+
+	// The noise, then the corrupt answer's SOP1 and the rest of it.
+	want := codec.Stats{Discarded: 4 + 6, ChecksumErrors: 1, Recovered: 1}
+	if st := s.FramingStats(); st != want {
+		t.Fatalf("Expected %+v but got %+v", want, st)
+	}
+}
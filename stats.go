@@ -0,0 +1,150 @@
+package sphero
+
+import (
+	"sync"
+	"time"
+
+	"github.com/FreeFlow/sphero/codec"
+)
+
+// Stats describes the health of the link to the Sphero. See Sphero.Stats.
+type Stats struct {
+	BytesSent     uint64
+	BytesReceived uint64
+
+	CommandsSent uint64 // Command frames written
+	Answers      uint64 // Answer frames received
+	Async        uint64 // Async frames received
+	AsyncDropped uint64 // Async responses dropped because the async channel was full, see SetDropAsync
+
+	// Answers by MRSP code, see the ORBOTIX_RSP_CODE constants.
+	Responses map[uint8]uint64
+
+	// Commands whose answer didn't arrive within the response timeout, and
+	// answers that arrived with no command waiting for them. Late answers
+	// alongside timeouts point at a slow link rather than a silent Sphero.
+	Timeouts    uint64
+	LateAnswers uint64
+
+	// Line noise recovered from: bytes discarded, checksum failures and
+	// frames recovered afterwards.
+	Framing codec.Stats
+
+	// Round trip times from sending a command to receiving its answer, over
+	// all commands and by command name (see CommandName).
+	Latency        Histogram
+	CommandLatency map[string]Histogram
+}
+
+// LatencyBuckets are the upper bounds of the Histogram buckets.
+var LatencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+}
+
+// Histogram counts round trip times. Counts[i] is the number of samples no
+// longer than LatencyBuckets[i], the last element counts the rest.
+type Histogram struct {
+	Counts   []uint64
+	Count    uint64
+	Sum      time.Duration
+	Min, Max time.Duration
+}
+
+// Mean returns the mean round trip time, or 0 without samples.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+func (h *Histogram) add(d time.Duration) {
+	if h.Counts == nil {
+		h.Counts = make([]uint64, len(LatencyBuckets)+1)
+	}
+	i := 0
+	for i < len(LatencyBuckets) && d > LatencyBuckets[i] {
+		i++
+	}
+	h.Counts[i]++
+	if h.Count == 0 || d < h.Min {
+		h.Min = d
+	}
+	if d > h.Max {
+		h.Max = d
+	}
+	h.Count++
+	h.Sum += d
+}
+
+func (h Histogram) clone() Histogram {
+	h.Counts = append([]uint64(nil), h.Counts...)
+	return h
+}
+
+// Link statistics, guarded by their own lock as they're updated on every
+// read and write.
+type linkStats struct {
+	mu sync.Mutex
+	s  Stats
+}
+
+// Stats returns a snapshot of the link statistics since the Sphero was
+// created.
+func (s *Sphero) Stats() Stats {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+
+	st := s.stats.s
+	st.Responses = make(map[uint8]uint64, len(s.stats.s.Responses))
+	for k, v := range s.stats.s.Responses {
+		st.Responses[k] = v
+	}
+	st.Latency = st.Latency.clone()
+	st.CommandLatency = make(map[string]Histogram, len(s.stats.s.CommandLatency))
+	for k, v := range s.stats.s.CommandLatency {
+		st.CommandLatency[k] = v.clone()
+	}
+	return st
+}
+
+func (l *linkStats) update(f func(*Stats)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f(&l.s)
+}
+
+// Records an answer, `matched` if a command was waiting for it and otherwise
+// a late answer.
+func (l *linkStats) answer(r *Response, matched bool) {
+	l.update(func(st *Stats) {
+		st.Answers++
+		if st.Responses == nil {
+			st.Responses = make(map[uint8]uint64)
+		}
+		st.Responses[r.Mrsp]++
+		if !matched {
+			st.LateAnswers++
+			return
+		}
+
+		rtt := r.RTT()
+		st.Latency.add(rtt)
+		if st.CommandLatency == nil {
+			st.CommandLatency = make(map[string]Histogram)
+		}
+		name := CommandName(r.Did, r.Cid)
+		h := st.CommandLatency[name]
+		h.add(rtt)
+		st.CommandLatency[name] = h
+	})
+}
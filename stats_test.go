@@ -0,0 +1,197 @@
+package sphero_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/FreeFlow/sphero"
+	"github.com/FreeFlow/sphero/codec"
+	"github.com/FreeFlow/sphero/spherosim"
+)
+
+func TestStats(t *testing.T) {
+	sim := spherosim.New()
+	defer sim.Close()
+
+	async := make(chan *sphero.AsyncResponse) // Nobody receives, so every async response is dropped
+	s := sphero.NewSpheroConn(sim.Conn(), async)
+	defer s.Close()
+	s.SetDropAsync(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for i := 0; i < 3; i++ {
+		if err := s.PingContext(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Do(ctx, sphero.DID_SPHERO, sphero.CMD_SET_BACK_LED, []byte{1, 2}); err != sphero.InvalidParametersError {
+		t.Fatalf("Expected InvalidParametersError but got %v", err)
+	}
+	if err := s.SetPowerNotificationContext(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	sim.SetPowerState(sphero.PowerState{RecVer: 1, PowerState: sphero.BATTERY_LOW})
+
+	deadline := time.Now().Add(time.Second)
+	for s.Stats().AsyncDropped == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the power notification to be dropped")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	st := s.Stats()
+	if st.CommandsSent != 5 || st.Answers != 5 || st.Async != 1 || st.AsyncDropped != 1 {
+		t.Fatalf("Unexpected frame counts %+v", st)
+	}
+	if st.Responses[sphero.ORBOTIX_RSP_CODE_OK] != 4 || st.Responses[sphero.ORBOTIX_RSP_CODE_EPARAM] != 1 {
+		t.Fatalf("Unexpected response codes %v", st.Responses)
+	}
+
+	// Pings are 7 bytes, frames grow by a byte per data byte. Answers are 6
+	// bytes and the notification 7.
+	if sent, received := uint64(3*7+9+8), uint64(5*6+7); st.BytesSent != sent || st.BytesReceived != received {
+		t.Fatalf("Expected %d bytes sent and %d received but got %d and %d", sent, received, st.BytesSent, st.BytesReceived)
+	}
+
+	if st.Latency.Count != 5 || len(st.Latency.Counts) != len(sphero.LatencyBuckets)+1 {
+		t.Fatalf("Unexpected latency histogram %+v", st.Latency)
+	}
+	if st.Latency.Min > st.Latency.Mean() || st.Latency.Mean() > st.Latency.Max {
+		t.Fatalf("Inconsistent latency histogram %+v", st.Latency)
+	}
+	if h := st.CommandLatency[sphero.CommandName(sphero.DID_CORE, sphero.CMD_PING)]; h.Count != 3 {
+		t.Fatalf("Expected 3 ping round trips but got %+v", h)
+	}
+}
+
+// Without SetDropAsync async responses wait for the channel.
+func TestAsyncNotDroppedByDefault(t *testing.T) {
+	sim := spherosim.New()
+	defer sim.Close()
+
+	async := make(chan *sphero.AsyncResponse)
+	s := sphero.NewSpheroConn(sim.Conn(), async)
+	defer s.Close()
+
+	if err := s.SetPowerNotificationContext(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	sim.SetPowerState(sphero.PowerState{RecVer: 1, PowerState: sphero.BATTERY_LOW})
+	time.Sleep(50 * time.Millisecond) // Nobody receiving yet
+
+	select {
+	case r := <-async:
+		if r.IdCode != sphero.ID_POWER_NOTIFICATIONS {
+			t.Fatalf("Unexpected async response %#v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The power notification was lost")
+	}
+	if st := s.Stats(); st.AsyncDropped != 0 {
+		t.Fatalf("Expected no drops but got %d", st.AsyncDropped)
+	}
+}
+
+func TestStatsTimeouts(t *testing.T) {
+	client, device := net.Pipe()
+	defer device.Close()
+
+	s := sphero.NewSpheroConn(client, nil)
+	defer s.Close()
+	s.SetResponseTimeout(10 * time.Millisecond)
+
+	ch := make(chan *sphero.Response, 1)
+	go s.Ping(ch)
+	ping := make([]byte, 7)
+	if _, err := io.ReadFull(device, ping); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-ch; r.Error() != sphero.ResponseTimeoutError {
+		t.Fatalf("Expected ResponseTimeoutError but got %v", r.Error())
+	}
+
+	// The answer turns up after all.
+	answer, _ := (&codec.AnswerPacket{Seq: ping[4]}).MarshalBinary()
+	device.Write(answer)
+
+	deadline := time.Now().Add(time.Second)
+	for s.Stats().LateAnswers == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a late answer")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if st := s.Stats(); st.Timeouts != 1 || st.Latency.Count != 0 {
+		t.Fatalf("Unexpected stats %+v", st)
+	}
+}
+
+func TestResponseMetadata(t *testing.T) {
+	sim := spherosim.New()
+	defer sim.Close()
+
+	s := sphero.NewSpheroConn(sim.Conn(), nil)
+	defer s.Close()
+
+	// Several commands answered on one channel.
+	ch := make(chan *sphero.Response, 3)
+	before := time.Now()
+	s.Ping(ch)
+	s.GetRGBLED(ch)
+	s.GetPowerState(ch)
+
+	want := map[uint8]uint8{
+		sphero.CMD_PING:          sphero.DID_CORE,
+		sphero.CMD_GET_RGB_LED:   sphero.DID_SPHERO,
+		sphero.CMD_GET_PWR_STATE: sphero.DID_CORE,
+	}
+	for i := 0; i < 3; i++ {
+		var r *sphero.Response
+		select {
+		case r = <-ch:
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for responses")
+		}
+		did, ok := want[r.Cid]
+		if !ok || r.Did != did {
+			t.Fatalf("Unexpected answer to %s %s", sphero.DeviceName(r.Did), sphero.CommandName(r.Did, r.Cid))
+		}
+		delete(want, r.Cid)
+
+		if r.Sent.Before(before) || r.Received.Before(r.Sent) {
+			t.Fatalf("%s: sent at %v and received at %v", sphero.CommandName(r.Did, r.Cid), r.Sent, r.Received)
+		}
+		if r.RTT() != r.Received.Sub(r.Sent) || r.RTT() <= 0 {
+			t.Fatalf("%s: unexpected RTT %v", sphero.CommandName(r.Did, r.Cid), r.RTT())
+		}
+	}
+}
+
+func TestTimeoutMetadata(t *testing.T) {
+	client, device := net.Pipe()
+	defer device.Close()
+	go io.Copy(io.Discard, device) // Never answers
+
+	s := sphero.NewSpheroConn(client, nil)
+	defer s.Close()
+	s.SetResponseTimeout(10 * time.Millisecond)
+
+	ch := make(chan *sphero.Response, 1)
+	s.GetRGBLED(ch)
+	r := <-ch
+	if r.Error() != sphero.ResponseTimeoutError {
+		t.Fatalf("Expected ResponseTimeoutError but got %v", r.Error())
+	}
+	if r.Did != sphero.DID_SPHERO || r.Cid != sphero.CMD_GET_RGB_LED || r.Sent.IsZero() {
+		t.Fatalf("Expected the timed out command in %+v", r)
+	}
+	if !r.Received.IsZero() || r.RTT() != 0 {
+		t.Fatalf("Expected no receive time but got %v", r.Received)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"time"
 )
 
 // Represents a command response.
@@ -15,10 +16,34 @@ type Response struct {
 	Dlen uint8
 	Data []byte
 	Chk  uint8
+
+	// The command answered, zero for answers no command was waiting for.
+	Did, Cid uint8
+
+	// When the command was sent and when its answer was received. Sent
+	// is zero for answers no command was waiting for, Received for requests
+	// that failed without an answer.
+	Sent, Received time.Time
+
+	cmd *Command // The command answered, nil if unknown
+	err error    // Set when the request failed without an answer
+}
+
+// RTT returns the round trip time from sending the command to receiving its
+// answer, or 0 if either is unknown.
+func (r *Response) RTT() time.Duration {
+	if r.Sent.IsZero() || r.Received.IsZero() {
+		return 0
+	}
+	return r.Received.Sub(r.Sent)
 }
 
-// Returns the appropriate error from the message response (MRSP) field, if any.
+// Returns the appropriate error from the message response (MRSP) field, if
+// any, or the reason no answer was received (e.g. DisconnectedError).
 func (r *Response) Error() (err error) {
+	if r.err != nil {
+		return r.err
+	}
 	switch r.Mrsp {
 	case ORBOTIX_RSP_CODE_OK:
 		err = nil
@@ -57,31 +82,102 @@ func (r *Response) Error() (err error) {
 }
 
 /*
-	Parses the data portion of the response into a PowerState struct.
-	This method may be inaccurate or fail if the data doesn't represent a color.
+	Parses the data portion of the response into a PowerState struct. See
+	GetPowerState.
+	Fails if the response answers a different command or its data has the
+	wrong length.
 */
 func (r *Response) PowerState() (*PowerState, error) {
 	ps := new(PowerState)
-	if len(r.Data) != binary.Size(ps) {
-		return ps, fmt.Errorf("Could not parse %#x as PowerState", ps)
-	}
-	buf := bytes.NewBuffer(r.Data)
-	binary.Read(buf, binary.BigEndian, ps)
-	return ps, nil
+	return ps, r.decode(DID_CORE, CMD_GET_PWR_STATE, ps)
 }
 
 /*
-	Parses the data portion of the response into a Color struct.
-	This method may be inaccurate or fail if the data doesn't represent a color.
+	Parses the data portion of the response into a Color struct. See
+	GetRGBLED.
+	Fails if the response answers a different command or its data has the
+	wrong length.
 */
 func (r *Response) Color() (*Color, error) {
 	c := new(Color)
-	if len(r.Data) != binary.Size(c) {
-		return c, fmt.Errorf("Could not parse %#x as Color", c)
+	return c, r.decode(DID_SPHERO, CMD_GET_RGB_LED, c)
+}
+
+/*
+	Parses the data portion of the response into a Version struct. See
+	GetVersion.
+	Fails if the response answers a different command or its data has the
+	wrong length.
+*/
+func (r *Response) Version() (*Version, error) {
+	var raw struct {
+		RecVer, Model, Hardware, MSAVer, MSARev, Bootloader, OrbBasic, Macro uint8
 	}
-	buf := bytes.NewBuffer(r.Data)
-	binary.Read(buf, binary.BigEndian, c)
-	return c, nil
+	if err := r.decode(DID_CORE, CMD_VERSION, &raw); err != nil {
+		return nil, err
+	}
+	v := &Version{
+		RecVer:     raw.RecVer,
+		Model:      raw.Model,
+		Hardware:   raw.Hardware,
+		MSA:        Firmware{raw.MSAVer, raw.MSARev},
+		Bootloader: nibbles(raw.Bootloader),
+		OrbBasic:   nibbles(raw.OrbBasic),
+		Macro:      raw.Macro,
+	}
+	if len(r.Data) >= 10 {
+		v.API = Firmware{r.Data[8], r.Data[9]}
+	}
+	return v, nil
+}
+
+/*
+	Decodes the response according to the command it answers:
+		- *PowerState for GetPowerState
+		- *Color for GetRGBLED
+		- *Version for GetVersion
+		- Values for other commands whose answer carries data, see Command
+		- nil for commands answered without data
+	Fails with the response's error, with UnboundResponseError if the
+	command isn't known, and with PayloadLayoutError if the data doesn't
+	match the command's answer layout.
+*/
+func (r *Response) Result() (interface{}, error) {
+	if err := r.Error(); err != nil {
+		return nil, err
+	}
+	if r.cmd == nil {
+		return nil, UnboundResponseError
+	}
+	switch r.cmd.Name {
+	case "CMD_GET_PWR_STATE":
+		return r.PowerState()
+	case "CMD_GET_RGB_LED":
+		return r.Color()
+	case "CMD_VERSION":
+		return r.Version()
+	}
+	vs, err := r.cmd.DecodeResponse(r.Data)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse %#x as %s answer: %w", r.Data, r.cmd.Name, err)
+	}
+	if len(vs) == 0 {
+		return nil, nil
+	}
+	return vs, nil
+}
+
+// Checks the response answers the command and its data matches the
+// command's answer layout, then reads it into `v`.
+func (r *Response) decode(did, cid uint8, v interface{}) error {
+	c := mustCommand(did, cid)
+	if r.cmd != nil && r.cmd != c {
+		return fmt.Errorf("%w: answer to %s parsed as %s", ResponseMismatchError, r.cmd.Name, c.Name)
+	}
+	if _, err := c.DecodeResponse(r.Data); err != nil {
+		return fmt.Errorf("Could not parse %#x as %T: %w", r.Data, v, err)
+	}
+	return binary.Read(bytes.NewReader(r.Data), binary.BigEndian, v)
 }
 
 /*
@@ -102,6 +198,8 @@ type AsyncResponse struct {
 /*
 	Unpacks sensor data from an async response.
 	This method may be inaccurate or fail if the data doesn't represent sensor data.
+	When streaming more than one frame per packet (see SetDataStreaming), pass
+	an array or slice with one element per frame.
 */
 func (r *AsyncResponse) Sensors(d interface{}) error {
 	buf := bytes.NewBuffer(r.Data)
@@ -138,11 +236,45 @@ func (r *AsyncResponse) Collision() (*Collision, error) {
 	return c, nil
 }
 
+/*
+	Parses the data portion of the async response into a SelfLevelResult.
+	See SelfLevel.
+	Fails if the response isn't an ID_SELF_LEVEL_RESULT or its data has the
+	wrong length.
+*/
+func (r *AsyncResponse) SelfLevelResult() (SelfLevelResult, error) {
+	if r.IdCode != ID_SELF_LEVEL_RESULT {
+		return SelfLevelUnknown, fmt.Errorf("%w: %s parsed as ID_SELF_LEVEL_RESULT", ResponseMismatchError, AsyncIdName(r.IdCode))
+	}
+	a, _ := LookupAsync(ID_SELF_LEVEL_RESULT)
+	if _, err := a.Decode(r.Data); err != nil {
+		return SelfLevelUnknown, fmt.Errorf("Could not parse %#x as SelfLevelResult: %w", r.Data, err)
+	}
+	return SelfLevelResult(r.Data[0]), nil
+}
+
 // Simple Color struct. See GetRGBLED.
 type Color struct {
 	R, G, B uint8
 }
 
+// Versions of the Sphero's hardware and software. See GetVersion.
+type Version struct {
+	RecVer     uint8 // Version of this record
+	Model      uint8
+	Hardware   uint8
+	MSA        Firmware // Main application
+	Bootloader Firmware
+	OrbBasic   Firmware
+	Macro      uint8    // Macro executive
+	API        Firmware // API version, zero before record version 2
+}
+
+// Unpacks a version in packed nibble format, e.g. 0x31 is 3.1.
+func nibbles(b uint8) Firmware {
+	return Firmware{b >> 4, b & 0x0f}
+}
+
 // Represents the power state of the Sphero. See SetPowerNotification.
 type PowerState struct {
 	RecVer, PowerState                    uint8
@@ -0,0 +1,79 @@
+package sphero
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	serial "github.com/FreeFlow/goserial"
+)
+
+// Transport is a named byte stream connected to a Sphero. Any
+// io.ReadWriteCloser can be passed to NewSpheroConn, implementing Transport
+// additionally lets the Sphero report where it's connected.
+type Transport interface {
+	io.ReadWriteCloser
+	Name() string
+}
+
+// Deadliner is implemented by transports that support read and write
+// deadlines, such as net.Conn.
+type Deadliner interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// serialTransport is a serial port along with the device name it was opened
+// with.
+type serialTransport struct {
+	io.ReadWriteCloser
+	name string
+}
+
+func (t *serialTransport) Name() string {
+	return t.name
+}
+
+// Read waits for data. Serial ports report io.EOF when the Sphero has
+// nothing to send before the port's read timeout, which isn't the end of the
+// stream, so those reads are retried.
+func (t *serialTransport) Read(data []byte) (int, error) {
+	for {
+		n, err := t.ReadWriteCloser.Read(data)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+	}
+}
+
+// OpenSerial opens the serial device at `name` with the settings the Sphero
+// expects (115200 baud).
+func OpenSerial(name string) (Transport, error) {
+	conf := &serial.Config{
+		Name: name,
+		Baud: 115200,
+	}
+
+	conn, err := serial.OpenPort(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &serialTransport{conn, name}, nil
+}
+
+// Reports whether a read error is part of normal operation: EBADF when the
+// port was closed while reading and timeouts from read deadlines. Anything
+// else, io.EOF included, means the link is gone.
+func isTransientReadError(err error) bool {
+	if errors.Is(err, syscall.EBADF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}